@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// AesGcmCipher encrypts and authenticates data with AES-GCM. A random nonce
+// is generated for every message and prefixed to the cipher text.
+type AesGcmCipher struct {
+	aead cipher.AEAD
+}
+
+func NewAesGcmCipher(key []byte) (*AesGcmCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AesGcmCipher{aead: aead}, nil
+}
+
+// Overhead is the number of bytes Encrypt adds to the plain text.
+func (a *AesGcmCipher) Overhead() int {
+	return a.aead.NonceSize() + a.aead.Overhead()
+}
+
+func (a *AesGcmCipher) Encrypt(plainText []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize(), a.aead.NonceSize()+len(plainText)+a.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return a.aead.Seal(nonce, nonce, plainText, nil), nil
+}
+
+func (a *AesGcmCipher) Decrypt(cipherText []byte) ([]byte, error) {
+	n := a.aead.NonceSize()
+	if len(cipherText) < n+a.aead.Overhead() {
+		return nil, errors.New("encrypted wrong")
+	}
+	return a.aead.Open(nil, cipherText[:n], cipherText[n:], nil)
+}
@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAesGcmCipher(t *testing.T) {
+	cipher, err := NewAesGcmCipher([]byte("1234567890ABCDEF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("hello world")
+	encrypted, err := cipher.Encrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, encrypted, len(msg)+cipher.Overhead())
+
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg, decrypted)
+
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = cipher.Decrypt(encrypted)
+	assert.Error(t, err)
+	_, err = cipher.Decrypt(encrypted[:4])
+	assert.Error(t, err)
+}
@@ -0,0 +1,19 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// HmacSha256 returns the HMAC-SHA256 of data.
+func HmacSha256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// VerifyHmacSha256 reports whether mac is the HMAC-SHA256 of data, in
+// constant time.
+func VerifyHmacSha256(key, data, mac []byte) bool {
+	return hmac.Equal(HmacSha256(key, data), mac)
+}
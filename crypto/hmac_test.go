@@ -0,0 +1,17 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHmacSha256(t *testing.T) {
+	key := []byte("key")
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	mac := HmacSha256(key, data)
+	assert.Equal(t, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", hex.EncodeToString(mac))
+	assert.True(t, VerifyHmacSha256(key, data, mac))
+	assert.False(t, VerifyHmacSha256([]byte("other"), data, mac))
+}
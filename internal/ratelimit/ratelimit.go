@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter keeps a token bucket per key, each refilled with rate tokens per
+// second up to burst tokens. A message is allowed when its key has a token
+// left.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	idle    time.Duration
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter of rate tokens per second with bursts of burst
+// tokens, at least one. The buckets of the keys not seen for idle are
+// forgotten, zero keeping them forever, which suits a bounded set of keys.
+func New(rate float64, burst int, idle time.Duration) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		idle:    idle,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key at now and reports whether
+// there was one.
+func (l *Limiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.idle > 0 && now.Sub(l.pruned) > l.idle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: l.burst,
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
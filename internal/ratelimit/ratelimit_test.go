@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(1, 2, 0)
+	now := time.Now()
+
+	assert.True(t, l.Allow("a", now))
+	assert.True(t, l.Allow("a", now))
+	assert.False(t, l.Allow("a", now))
+	assert.True(t, l.Allow("b", now))
+	assert.True(t, l.Allow("a", now.Add(time.Second)))
+
+	l.Allow("b", now.Add(time.Hour))
+	assert.Len(t, l.buckets, 2)
+}
+
+func TestLimiterIdle(t *testing.T) {
+	l := New(1, 2, time.Minute)
+	now := time.Now()
+	assert.True(t, l.Allow("a", now))
+	assert.True(t, l.Allow("b", now))
+
+	// the keys idle for a minute are forgotten
+	l.Allow("b", now.Add(time.Minute*2))
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l := New(1, 0, 0)
+	now := time.Now()
+	assert.True(t, l.Allow("a", now))
+	assert.False(t, l.Allow("a", now))
+}
@@ -0,0 +1,177 @@
+package route
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	sep = "."
+	// one matches exactly one segment of a cmd.
+	one = "*"
+	// rest matches one or more trailing segments of a cmd.
+	rest = "**"
+)
+
+// Route is a registered pattern and the value routed to it.
+type Route struct {
+	Pattern string
+	Value   interface{}
+}
+
+// Router matches cmds against exact and wildcard patterns. Cmds are split
+// into segments by ".", in patterns "*" matches one segment and a trailing
+// "**" matches the remaining segments, so "device.*" matches
+// "device.status" and "device.**" matches "device.status.get" too.
+//
+// Exact patterns win over wildcard ones; among wildcard patterns the one
+// with the longest literal prefix wins, "*" winning over "**".
+//
+// Router is not safe for concurrent registration, routes are expected to
+// be registered before serving.
+type Router struct {
+	exact    map[string]interface{}
+	patterns []*pattern
+	routes   []string
+}
+
+type pattern struct {
+	raw      string
+	segments []string
+	value    interface{}
+}
+
+func New() *Router {
+	return &Router{
+		exact: make(map[string]interface{}),
+	}
+}
+
+// Add registers value for pattern, replacing the value of an identical
+// pattern.
+func (r *Router) Add(raw string, value interface{}) {
+	if !isPattern(raw) {
+		if _, ok := r.exact[raw]; !ok {
+			r.routes = append(r.routes, raw)
+		}
+		r.exact[raw] = value
+		return
+	}
+
+	for _, p := range r.patterns {
+		if p.raw == raw {
+			p.value = value
+			return
+		}
+	}
+	r.routes = append(r.routes, raw)
+	r.patterns = append(r.patterns, &pattern{
+		raw:      raw,
+		segments: strings.Split(raw, sep),
+		value:    value,
+	})
+	sort.SliceStable(r.patterns, func(i, j int) bool {
+		return morePrecise(r.patterns[i].segments, r.patterns[j].segments)
+	})
+}
+
+// Get returns the value registered for exactly pattern.
+func (r *Router) Get(raw string) (interface{}, bool) {
+	if value, ok := r.exact[raw]; ok {
+		return value, true
+	}
+	for _, p := range r.patterns {
+		if p.raw == raw {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+// Match returns the value of the most precise pattern matching cmd.
+func (r *Router) Match(cmd string) (interface{}, bool) {
+	if value, ok := r.exact[cmd]; ok {
+		return value, true
+	}
+	if len(r.patterns) == 0 {
+		return nil, false
+	}
+
+	segments := strings.Split(cmd, sep)
+	for _, p := range r.patterns {
+		if match(p.segments, segments) {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+// Routes returns the registered routes in registration order.
+func (r *Router) Routes() []Route {
+	routes := make([]Route, 0, len(r.routes))
+	for _, raw := range r.routes {
+		value, _ := r.Get(raw)
+		routes = append(routes, Route{
+			Pattern: raw,
+			Value:   value,
+		})
+	}
+	return routes
+}
+
+// FuncName returns the name of the function f, for route introspection.
+func FuncName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return ""
+	}
+	return fn.Name()
+}
+
+func isPattern(raw string) bool {
+	for _, segment := range strings.Split(raw, sep) {
+		if segment == one || segment == rest {
+			return true
+		}
+	}
+	return false
+}
+
+func match(pattern []string, segments []string) bool {
+	for i, p := range pattern {
+		if p == rest && i == len(pattern)-1 {
+			return len(segments) > i
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if p != one && p != segments[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+// morePrecise reports whether pattern a should be tried before pattern b.
+func morePrecise(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ra, rb := rank(a[i]), rank(b[i])
+		if ra != rb {
+			return ra < rb
+		}
+	}
+	return len(a) > len(b)
+}
+
+func rank(segment string) int {
+	switch segment {
+	case one:
+		return 1
+	case rest:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,53 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterMatch(t *testing.T) {
+	r := New()
+	r.Add("device.**", "device.**")
+	r.Add("device.*", "device.*")
+	r.Add("device.status", "device.status")
+	r.Add("*.status", "*.status")
+	r.Add("**", "**")
+
+	cases := map[string]string{
+		"device.status":     "device.status",
+		"device.reboot":     "device.*",
+		"device.status.get": "device.**",
+		"user.status":       "*.status",
+		"user":              "**",
+		"user.login.failed": "**",
+	}
+	for cmd, want := range cases {
+		value, ok := r.Match(cmd)
+		assert.True(t, ok, cmd)
+		assert.Equal(t, want, value, cmd)
+	}
+}
+
+func TestRouterNoMatch(t *testing.T) {
+	r := New()
+	r.Add("device.*", 1)
+	r.Add("user", 2)
+
+	for _, cmd := range []string{"device", "device.a.b", "users", ""} {
+		_, ok := r.Match(cmd)
+		assert.False(t, ok, cmd)
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	r := New()
+	r.Add("b", 1)
+	r.Add("a.*", 2)
+	r.Add("b", 3)
+
+	assert.Equal(t, []Route{
+		{Pattern: "b", Value: 3},
+		{Pattern: "a.*", Value: 2},
+	}, r.Routes())
+}
@@ -0,0 +1,60 @@
+package multicast
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// packetConn is the part of ipv4.PacketConn and ipv6.PacketConn used by the
+// server, so that both address families share the same code.
+type packetConn interface {
+	JoinGroup(ifi *net.Interface, group net.Addr) error
+	LeaveGroup(ifi *net.Interface, group net.Addr) error
+	SetMulticastInterface(ifi *net.Interface) error
+	SetMulticastLoopback(on bool) error
+	// WriteToInterface sends b to dst through the interface with ifIndex,
+	// or the multicast interface of the connection when ifIndex is 0.
+	WriteToInterface(b []byte, ifIndex int, dst net.Addr) (int, error)
+}
+
+type ipv4Conn struct {
+	*ipv4.PacketConn
+}
+
+func (c ipv4Conn) WriteToInterface(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	var cm *ipv4.ControlMessage
+	if ifIndex > 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	return c.WriteTo(b, cm, dst)
+}
+
+type ipv6Conn struct {
+	*ipv6.PacketConn
+}
+
+func (c ipv6Conn) WriteToInterface(b []byte, ifIndex int, dst net.Addr) (int, error) {
+	var cm *ipv6.ControlMessage
+	if ifIndex > 0 {
+		cm = &ipv6.ControlMessage{IfIndex: ifIndex}
+	}
+	return c.WriteTo(b, cm, dst)
+}
+
+// newPacketConn wraps conn for the address family of group.
+func newPacketConn(conn *net.UDPConn, group net.IP) packetConn {
+	if group.To4() != nil {
+		return ipv4Conn{ipv4.NewPacketConn(conn)}
+	}
+	return ipv6Conn{ipv6.NewPacketConn(conn)}
+}
+
+// network returns the udp network of group.
+func network(group net.IP) string {
+	if group.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
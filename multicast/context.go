@@ -27,9 +27,26 @@ type Message struct {
 	Byte []byte
 }
 
+// RespBody is the envelope of a response. Reply marks the answers of
+// MessageContext.Reply and ReplyError, which go to the pending Request
+// instead of the handlers.
+type RespBody struct {
+	Cmd   string      `json:"cmd"`
+	Seqno string      `json:"seqno,omitempty"`
+	Reply bool        `json:"reply,omitempty"`
+	Code  int         `json:"code"`
+	Msg   string      `json:"msg"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// MessageBody is the envelope of an inbound message. Code and Msg are only
+// set when the message is a response, Reply when it answers a Request.
 type MessageBody struct {
 	Cmd   string          `json:"cmd"`
 	Seqno string          `json:"seqno,omitempty"`
+	Reply bool            `json:"reply,omitempty"`
+	Code  int             `json:"code,omitempty"`
+	Msg   string          `json:"msg,omitempty"`
 	Data  json.RawMessage `json:"data,omitempty"`
 }
 
@@ -95,6 +112,35 @@ func (c *MessageContext) ShouldBindJson(v interface{}) error {
 	return nil
 }
 
+// Reply unicasts a successful RespBody carrying data to the source of the
+// message.
+func (c *MessageContext) Reply(data interface{}) error {
+	return c.Server.sendTo(&RespBody{
+		Cmd:   c.Body.Cmd,
+		Seqno: c.Body.Seqno,
+		Reply: true,
+		Code:  OKCode,
+		Msg:   OKMsg,
+		Data:  data,
+	}, c.Message.Src)
+}
+
+// ReplyError unicasts the code and msg of err to the source of the
+// message, falling back to ErrServerError when err is not an *Error.
+func (c *MessageContext) ReplyError(err error) error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = ErrServerError
+	}
+	return c.Server.sendTo(&RespBody{
+		Cmd:   c.Body.Cmd,
+		Seqno: c.Body.Seqno,
+		Reply: true,
+		Code:  e.Code,
+		Msg:   e.Msg,
+	}, c.Message.Src)
+}
+
 func (c *MessageContext) LogError(err error) {
 	logx.Error("handle message failed, cmd:%s, seq:%s, err:%s", c.Body.Cmd, c.Body.Seqno, err)
 	c.Error = err
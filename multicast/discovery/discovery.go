@@ -0,0 +1,373 @@
+// Package discovery finds peers through a multicast.Server. Every node
+// periodically announces its descriptor, keeps a table of the peers heard
+// with a TTL and answers explicit queries.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xuzq3/glib/logx"
+	"github.com/xuzq3/glib/multicast"
+)
+
+// Reserved cmds of the discovery protocol.
+const (
+	AnnounceCmd = "discovery.announce"
+	LeaveCmd    = "discovery.leave"
+	QueryCmd    = "discovery.query"
+)
+
+const (
+	defaultInterval = time.Second * 10
+	defaultTTL      = time.Second * 30
+)
+
+var (
+	ErrStarted = errors.New("discovery already started")
+)
+
+// Node describes a node of the network.
+type Node struct {
+	ID        string            `json:"id"`
+	Service   string            `json:"service"`
+	Addresses []string          `json:"addresses,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+// Peer is a node heard on the network.
+type Peer struct {
+	Node
+	Src      *net.UDPAddr
+	LastSeen time.Time
+	// Expires is when the peer leaves unless it announces again.
+	Expires time.Time
+}
+
+type EventType int
+
+const (
+	EventJoin EventType = iota
+	EventUpdate
+	EventLeave
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoin:
+		return "join"
+	case EventUpdate:
+		return "update"
+	case EventLeave:
+		return "leave"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a change of the peer table.
+type Event struct {
+	Type EventType
+	Peer Peer
+}
+
+type EventHandler func(e Event)
+
+type announce struct {
+	Node
+	// TTL is how long the receivers keep the node, in milliseconds.
+	TTL int64 `json:"ttl"`
+}
+
+type query struct {
+	Service string `json:"service,omitempty"`
+}
+
+type Option func(d *Discovery)
+
+// WithInterval sets the period of the announcements.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Discovery) {
+		d.interval = interval
+	}
+}
+
+// WithTTL sets how long the peers keep this node after an announcement. It
+// should be a few times the interval, so a lost packet doesn't drop the
+// node.
+func WithTTL(ttl time.Duration) Option {
+	return func(d *Discovery) {
+		d.ttl = ttl
+	}
+}
+
+type Discovery struct {
+	server   *multicast.Server
+	node     Node
+	interval time.Duration
+	ttl      time.Duration
+	handlers []EventHandler
+
+	mu     sync.Mutex
+	peers  map[string]*Peer
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New registers the discovery cmds on server for node. The server is
+// started and stopped by the caller.
+func New(server *multicast.Server, node Node, opts ...Option) *Discovery {
+	d := &Discovery{
+		server:   server,
+		node:     node,
+		interval: defaultInterval,
+		ttl:      defaultTTL,
+		peers:    make(map[string]*Peer),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	server.OnCmd(AnnounceCmd, d.onAnnounce)
+	server.OnCmd(LeaveCmd, d.onLeave)
+	server.OnCmd(QueryCmd, d.onQuery)
+	return d
+}
+
+// OnEvent adds handlers called when a peer joins, updates its descriptor
+// or leaves. It must be called before Start.
+func (d *Discovery) OnEvent(handlers ...EventHandler) {
+	d.handlers = append(d.handlers, handlers...)
+}
+
+// Start announces the node now and then every interval, and expires the
+// peers that stopped announcing.
+func (d *Discovery) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		return ErrStarted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	go d.loop(ctx, d.done)
+	return nil
+}
+
+// Stop stops announcing and tells the peers that the node leaves.
+func (d *Discovery) Stop() error {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.cancel = nil
+	d.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	return d.server.SendJson(&multicast.Body{
+		Cmd:   LeaveCmd,
+		Seqno: multicast.NewSeqno(),
+		Data:  &d.node,
+	})
+}
+
+func (d *Discovery) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	d.announce()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.announce()
+			d.expire(time.Now())
+		}
+	}
+}
+
+func (d *Discovery) announce() {
+	err := d.server.SendJson(&multicast.Body{
+		Cmd:   AnnounceCmd,
+		Seqno: multicast.NewSeqno(),
+		Data: &announce{
+			Node: d.node,
+			TTL:  d.ttl.Milliseconds(),
+		},
+	})
+	if err != nil {
+		logx.WithError(err).Error("discovery announce failed")
+	}
+}
+
+// Peers returns the known peers ordered by ID.
+func (d *Discovery) Peers() []Peer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := make([]Peer, 0, len(d.peers))
+	for _, p := range d.peers {
+		peers = append(peers, *p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+	return peers
+}
+
+// Lookup returns the known peers of service ordered by ID.
+func (d *Discovery) Lookup(service string) []Peer {
+	peers := d.Peers()
+	list := peers[:0]
+	for _, p := range peers {
+		if p.Service == service {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// Query asks the network for the nodes of service, or all nodes when
+// service is empty, and collects the answers until ctx is done. The
+// answers also refresh the peer table.
+func (d *Discovery) Query(ctx context.Context, service string) ([]Peer, error) {
+	resps, err := d.server.Request(ctx, QueryCmd, &query{Service: service})
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(resps))
+	for _, resp := range resps {
+		if resp.Body.Code != multicast.OKCode {
+			continue
+		}
+		var a announce
+		err = json.Unmarshal(resp.Body.Data, &a)
+		if err != nil || a.ID == "" || a.ID == d.node.ID {
+			continue
+		}
+		peers = append(peers, d.update(&a, resp.Src, time.Now()))
+	}
+	return peers, nil
+}
+
+func (d *Discovery) onAnnounce(c *multicast.MessageContext) {
+	var a announce
+	err := c.ShouldBindJson(&a)
+	if err != nil {
+		c.LogError(err)
+		return
+	}
+	if a.ID == "" || a.ID == d.node.ID {
+		return
+	}
+	d.update(&a, c.Message.Src, time.Now())
+}
+
+func (d *Discovery) onLeave(c *multicast.MessageContext) {
+	var node Node
+	err := c.ShouldBindJson(&node)
+	if err != nil {
+		c.LogError(err)
+		return
+	}
+
+	d.mu.Lock()
+	p, ok := d.peers[node.ID]
+	delete(d.peers, node.ID)
+	d.mu.Unlock()
+	if ok {
+		d.emit(Event{Type: EventLeave, Peer: *p})
+	}
+}
+
+func (d *Discovery) onQuery(c *multicast.MessageContext) {
+	var q query
+	err := c.ShouldBindJson(&q)
+	if err != nil {
+		c.LogError(err)
+		return
+	}
+	if q.Service != "" && q.Service != d.node.Service {
+		return
+	}
+	err = c.Reply(&announce{
+		Node: d.node,
+		TTL:  d.ttl.Milliseconds(),
+	})
+	if err != nil {
+		c.LogError(err)
+	}
+}
+
+// update records an announcement and emits the resulting event.
+func (d *Discovery) update(a *announce, src *net.UDPAddr, now time.Time) Peer {
+	ttl := time.Duration(a.TTL) * time.Millisecond
+	if ttl <= 0 {
+		ttl = d.ttl
+	}
+
+	d.mu.Lock()
+	old, ok := d.peers[a.ID]
+	p := &Peer{
+		Node:     a.Node,
+		Src:      src,
+		LastSeen: now,
+		Expires:  now.Add(ttl),
+	}
+	d.peers[a.ID] = p
+	d.mu.Unlock()
+
+	if !ok {
+		d.emit(Event{Type: EventJoin, Peer: *p})
+	} else if !sameNode(&old.Node, &p.Node) {
+		d.emit(Event{Type: EventUpdate, Peer: *p})
+	}
+	return *p
+}
+
+// expire removes the peers whose TTL passed.
+func (d *Discovery) expire(now time.Time) {
+	var expired []Peer
+	d.mu.Lock()
+	for id, p := range d.peers {
+		if now.After(p.Expires) {
+			delete(d.peers, id)
+			expired = append(expired, *p)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, p := range expired {
+		d.emit(Event{Type: EventLeave, Peer: p})
+	}
+}
+
+func (d *Discovery) emit(e Event) {
+	for _, handler := range d.handlers {
+		handler(e)
+	}
+}
+
+func sameNode(a, b *Node) bool {
+	if a.Service != b.Service || len(a.Addresses) != len(b.Addresses) || len(a.Meta) != len(b.Meta) {
+		return false
+	}
+	for i := range a.Addresses {
+		if a.Addresses[i] != b.Addresses[i] {
+			return false
+		}
+	}
+	for k, v := range a.Meta {
+		if bv, ok := b.Meta[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/multicast"
+)
+
+func TestDiscovery(t *testing.T) {
+	s := multicast.NewServer("0.0.0.0", "239.0.0.14", 19016, 1024)
+	s.SetLoopback(true)
+	d := New(s, Node{ID: "self", Service: "gateway"}, WithInterval(time.Hour))
+	events := make(chan Event, 4)
+	d.OnEvent(func(e Event) {
+		events <- e
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	assert.Eventually(t, func() bool {
+		return len(s.Interfaces()) > 0
+	}, time.Second, time.Millisecond*10)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrStarted, d.Start())
+
+	expect := func(typ EventType, id string) {
+		select {
+		case e := <-events:
+			assert.Equal(t, typ, e.Type)
+			assert.Equal(t, id, e.Peer.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", typ)
+		}
+	}
+
+	// another node announces on the group
+	peer := &announce{Node: Node{ID: "peer", Service: "camera"}, TTL: 60000}
+	assert.NoError(t, s.SendJson(&multicast.Body{Cmd: AnnounceCmd, Data: peer}))
+	expect(EventJoin, "peer")
+	peer.Meta = map[string]string{"fw": "2"}
+	assert.NoError(t, s.SendJson(&multicast.Body{Cmd: AnnounceCmd, Data: peer}))
+	expect(EventUpdate, "peer")
+	assert.Len(t, d.Lookup("camera"), 1)
+	assert.Len(t, d.Lookup("gateway"), 0)
+
+	assert.NoError(t, s.SendJson(&multicast.Body{Cmd: LeaveCmd, Data: &peer.Node}))
+	expect(EventLeave, "peer")
+	assert.Empty(t, d.Peers())
+
+	// the node answers queries of its service only
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	resps, err := s.Request(ctx, QueryCmd, &query{Service: "gateway"})
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 1) {
+		assert.Contains(t, string(resps[0].Body.Data), `"id":"self"`)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	resps, err = s.Request(ctx, QueryCmd, &query{Service: "camera"})
+	assert.NoError(t, err)
+	assert.Empty(t, resps)
+
+	assert.NoError(t, d.Stop())
+}
+
+func TestExpire(t *testing.T) {
+	s := multicast.NewServer("0.0.0.0", "239.0.0.14", 19016, 1024)
+	d := New(s, Node{ID: "self"})
+	var events []Event
+	d.OnEvent(func(e Event) {
+		events = append(events, e)
+	})
+
+	now := time.Now()
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 19016}
+	d.update(&announce{Node: Node{ID: "a"}, TTL: 1000}, src, now)
+	d.update(&announce{Node: Node{ID: "b"}, TTL: 5000}, src, now)
+	d.expire(now.Add(time.Second * 2))
+
+	assert.Len(t, events, 3)
+	assert.Equal(t, EventLeave, events[2].Type)
+	assert.Equal(t, "a", events[2].Peer.ID)
+	peers := d.Peers()
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, "b", peers[0].ID)
+		assert.Equal(t, src, peers[0].Src)
+	}
+}
@@ -0,0 +1,211 @@
+package multicast
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuzq3/glib/internal/ratelimit"
+)
+
+// DropPolicy decides what happens to a packet received while the queue of
+// the worker pool is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the packet received.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the packet waiting longest to make room.
+	DropOldest
+	// Block stops reading until a worker takes a packet, leaving the
+	// socket buffer of the kernel to absorb or drop the burst.
+	Block
+)
+
+const (
+	defaultPoolWorkers   = 8
+	defaultPoolQueueSize = 256
+)
+
+// WorkerPool configures the handling of the packets received. Without it
+// every packet is handled on its own goroutine.
+type WorkerPool struct {
+	// Workers is the number of goroutines handling packets, 8 by default.
+	Workers int
+	// QueueSize bounds the packets waiting for a worker, 256 by default.
+	QueueSize int
+	// Policy applies when the queue is full.
+	Policy DropPolicy
+	// OrderBySource hands the packets of a source to the same worker, so
+	// they are handled in the order received.
+	OrderBySource bool
+	// Rate limits every source IP to Rate packets per second with bursts
+	// of Burst packets. Zero disables the limit.
+	Rate  float64
+	Burst int
+}
+
+// Stats counts the packets received by a server with a worker pool.
+type Stats struct {
+	Received uint64
+	// Dropped counts the packets dropped because the queue was full.
+	Dropped uint64
+	// Limited counts the packets dropped by the rate limit.
+	Limited uint64
+}
+
+// SetWorkerPool hands the packets received to a bounded pool of workers.
+// It must be called before Start, nil restores a goroutine per packet.
+func (s *Server) SetWorkerPool(pool *WorkerPool) {
+	if pool == nil {
+		s.pool = nil
+		return
+	}
+	p := *pool
+	if p.Workers < 1 {
+		p.Workers = defaultPoolWorkers
+	}
+	if p.QueueSize < 1 {
+		p.QueueSize = defaultPoolQueueSize
+	}
+	s.pool = &p
+}
+
+// Stats returns the counters of the worker pool, zero without one.
+func (s *Server) Stats() Stats {
+	d := s.dispatcher
+	if d == nil {
+		return Stats{}
+	}
+	return Stats{
+		Received: atomic.LoadUint64(&d.received),
+		Dropped:  atomic.LoadUint64(&d.dropped),
+		Limited:  atomic.LoadUint64(&d.limited),
+	}
+}
+
+type packet struct {
+	n   int
+	src *net.UDPAddr
+	b   []byte
+}
+
+type dispatcher struct {
+	server  *Server
+	pool    *WorkerPool
+	queues  []chan *packet
+	limiter *ratelimit.Limiter
+	wg      sync.WaitGroup
+	aborted chan struct{}
+
+	received uint64
+	dropped  uint64
+	limited  uint64
+}
+
+func (s *Server) newDispatcher(pool *WorkerPool) *dispatcher {
+	d := &dispatcher{
+		server:  s,
+		pool:    pool,
+		aborted: make(chan struct{}),
+	}
+	if pool.Rate > 0 {
+		// forget the sources idle for a minute
+		d.limiter = ratelimit.New(pool.Rate, pool.Burst, time.Minute)
+	}
+
+	if pool.OrderBySource {
+		size := pool.QueueSize / pool.Workers
+		if size < 1 {
+			size = 1
+		}
+		d.queues = make([]chan *packet, pool.Workers)
+		for i := range d.queues {
+			d.queues[i] = make(chan *packet, size)
+			d.wg.Add(1)
+			go d.work(d.queues[i])
+		}
+	} else {
+		queue := make(chan *packet, pool.QueueSize)
+		d.queues = []chan *packet{queue}
+		for i := 0; i < pool.Workers; i++ {
+			d.wg.Add(1)
+			go d.work(queue)
+		}
+	}
+	return d
+}
+
+func (d *dispatcher) dispatch(ctx context.Context, p *packet) {
+	atomic.AddUint64(&d.received, 1)
+	if d.limiter != nil && !d.limiter.Allow(p.src.IP.String(), time.Now()) {
+		atomic.AddUint64(&d.limited, 1)
+		d.server.bytePool.Put(p.b)
+		return
+	}
+
+	queue := d.queues[0]
+	if len(d.queues) > 1 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(p.src.String()))
+		queue = d.queues[h.Sum32()%uint32(len(d.queues))]
+	}
+
+	switch d.pool.Policy {
+	case Block:
+		select {
+		case queue <- p:
+		case <-ctx.Done():
+			d.server.bytePool.Put(p.b)
+		}
+		return
+	case DropOldest:
+		select {
+		case queue <- p:
+			return
+		default:
+		}
+		select {
+		case old := <-queue:
+			atomic.AddUint64(&d.dropped, 1)
+			d.server.bytePool.Put(old.b)
+		default:
+		}
+	}
+
+	select {
+	case queue <- p:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+		d.server.bytePool.Put(p.b)
+	}
+}
+
+func (d *dispatcher) work(queue chan *packet) {
+	defer d.wg.Done()
+	for p := range queue {
+		select {
+		case <-d.aborted:
+			d.server.bytePool.Put(p.b)
+			continue
+		default:
+		}
+		d.server.handle(p.n, p.src, p.b)
+	}
+}
+
+// close lets the workers handle the packets queued and return. It must be
+// called once recv returned, as dispatch sends on the queues.
+func (d *dispatcher) close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+}
+
+// abort drops the packets still queued instead of handling them.
+func (d *dispatcher) abort() {
+	close(d.aborted)
+}
@@ -0,0 +1,112 @@
+package multicast
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPacket(s *Server, src *net.UDPAddr, seqno int) *packet {
+	b := s.bytePool.Get()
+	n := copy(b, `{"cmd":"work","seqno":"`+strconv.Itoa(seqno)+`"}`)
+	return &packet{n: n, src: src, b: b}
+}
+
+func TestDispatchDrop(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.1", 9999, 1024)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s.OnCmd("work", func(c *MessageContext) {
+		started <- struct{}{}
+		<-release
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.SetWorkerPool(&WorkerPool{Workers: 1, QueueSize: 2})
+	s.dispatcher = s.newDispatcher(s.pool)
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 9999}
+
+	s.dispatcher.dispatch(ctx, newPacket(s, src, 0))
+	<-started
+	for i := 1; i < 5; i++ {
+		s.dispatcher.dispatch(ctx, newPacket(s, src, i))
+	}
+	assert.Equal(t, Stats{Received: 5, Dropped: 2}, s.Stats())
+	close(release)
+}
+
+func TestDispatchOrderBySource(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.1", 9999, 1024)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seqnos := make(map[string][]string)
+	s.OnCmd("work", func(c *MessageContext) {
+		mu.Lock()
+		src := c.Message.Src.String()
+		seqnos[src] = append(seqnos[src], c.Body.Seqno)
+		mu.Unlock()
+		wg.Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.SetWorkerPool(&WorkerPool{Workers: 4, QueueSize: 64, Policy: Block, OrderBySource: true})
+	s.dispatcher = s.newDispatcher(s.pool)
+
+	srcs := []*net.UDPAddr{
+		{IP: net.IPv4(192, 168, 1, 2), Port: 9999},
+		{IP: net.IPv4(192, 168, 1, 3), Port: 9999},
+	}
+	var want []string
+	for i := 0; i < 100; i++ {
+		want = append(want, strconv.Itoa(i))
+		for _, src := range srcs {
+			wg.Add(1)
+			s.dispatcher.dispatch(ctx, newPacket(s, src, i))
+		}
+	}
+	wg.Wait()
+	for _, src := range srcs {
+		assert.Equal(t, want, seqnos[src.String()])
+	}
+	assert.Equal(t, Stats{Received: 200}, s.Stats())
+}
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.21", 19029, 1024)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var handled int32
+	s.OnCmd("work", func(c *MessageContext) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		atomic.AddInt32(&handled, 1)
+	})
+	s.SetWorkerPool(&WorkerPool{Workers: 1, QueueSize: 4})
+	startLoopback(t, s)
+	s.dispatcher = s.newDispatcher(s.pool)
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 9999}
+
+	s.dispatcher.dispatch(s.ctx, newPacket(s, src, 0))
+	<-started
+	for i := 1; i < 4; i++ {
+		s.dispatcher.dispatch(s.ctx, newPacket(s, src, i))
+	}
+	time.AfterFunc(time.Millisecond*50, func() {
+		close(release)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&handled))
+	assert.Equal(t, Stats{Received: 4}, s.Stats())
+}
@@ -1,6 +1,10 @@
 package multicast
 
-import "errors"
+import (
+	"errors"
+	"sort"
+	"strings"
+)
 
 const (
 	OKCode = 0
@@ -8,7 +12,10 @@ const (
 )
 
 var (
-	ErrUnknownCmd = errors.New("unknown command")
+	ErrUnknownCmd       = errors.New("unknown command")
+	ErrUnknownInterface = errors.New("interface not joined")
+	ErrServerStarted    = errors.New("multicast server already started")
+	ErrServerClosed     = errors.New("multicast server closed")
 )
 
 type Error struct {
@@ -33,3 +40,21 @@ var (
 	ErrHandleTimeout     = NewError(10002, "handle timeout")
 	ErrInvalidParams     = NewError(10003, "invalid params")
 )
+
+// SendError reports the interfaces a message couldn't be sent on.
+type SendError struct {
+	Errors map[string]error
+}
+
+func (e *SendError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+": "+e.Errors[name].Error())
+	}
+	return "multicast send failed on " + strings.Join(parts, ", ")
+}
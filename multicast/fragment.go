@@ -0,0 +1,238 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A fragment is laid out as
+//
+//	magic | id (4 bytes) | index (2 bytes) | count (2 bytes) | data
+//
+// The magic byte can't start a json message, so unfragmented messages are
+// sent unchanged. Fragments are sealed one by one when security is on.
+// Fragments are never empty, so a message of maxSize bytes has at most
+// maxSize fragments.
+const (
+	fragMagic      = 0xf1
+	fragHeaderSize = 1 + 4 + 2 + 2
+
+	defaultFragTimeout    = time.Second * 5
+	defaultFragMaxPartial = 64
+	defaultFragMaxSize    = 1024 * 1024
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrInvalidFragment = errors.New("invalid fragment")
+	ErrMTUTooSmall     = errors.New("mtu too small")
+)
+
+// Fragmentation configures the splitting of the messages larger than MTU.
+// All members of the group must enable it.
+type Fragmentation struct {
+	// MTU is the largest packet sent, including the security overhead. It
+	// must not exceed the buffer size of the receivers.
+	MTU int
+	// Timeout drops an incomplete message when none of its fragments
+	// arrived for that long, 5 seconds by default.
+	Timeout time.Duration
+	// MaxPartial bounds the incomplete messages kept at once, the oldest is
+	// dropped first. 64 by default.
+	MaxPartial int
+	// MaxSize bounds the size of a reassembled message, 1MB by default.
+	MaxSize int
+}
+
+// SetFragmentation splits the messages larger than the MTU into fragments
+// and reassembles the fragments received. It must be called before Start,
+// nil disables it.
+func (s *Server) SetFragmentation(f *Fragmentation) error {
+	if f == nil {
+		s.fragmenter = nil
+		return nil
+	}
+	if f.MTU > s.buffSize {
+		return fmt.Errorf("mtu %d exceeds the buffer size %d", f.MTU, s.buffSize)
+	}
+	fr := newFragmenter(f)
+	err := fr.setOverhead(s.securityOverhead())
+	if err != nil {
+		return err
+	}
+	s.fragmenter = fr
+	return nil
+}
+
+// securityOverhead is the number of bytes the security adds to a packet.
+func (s *Server) securityOverhead() int {
+	if s.security == nil {
+		return 0
+	}
+	return s.security.overhead()
+}
+
+type partial struct {
+	// frags is sparse, the count of a spoofed fragment must not size it
+	frags   map[int][]byte
+	count   int
+	size    int
+	expires time.Time
+}
+
+type fragmenter struct {
+	mtu        int
+	chunkSize  int
+	timeout    time.Duration
+	maxPartial int
+	maxSize    int
+	id         uint32
+
+	mu       sync.Mutex
+	partials map[string]*partial
+}
+
+func newFragmenter(f *Fragmentation) *fragmenter {
+	fr := &fragmenter{
+		mtu:        f.MTU,
+		timeout:    f.Timeout,
+		maxPartial: f.MaxPartial,
+		maxSize:    f.MaxSize,
+		id:         rand.Uint32(),
+		partials:   make(map[string]*partial),
+	}
+	if fr.timeout <= 0 {
+		fr.timeout = defaultFragTimeout
+	}
+	if fr.maxPartial <= 0 {
+		fr.maxPartial = defaultFragMaxPartial
+	}
+	if fr.maxSize <= 0 {
+		fr.maxSize = defaultFragMaxSize
+	}
+	return fr
+}
+
+// setOverhead sizes the fragments so that they fit the MTU once sealed.
+// Start sizes them again, as the security may be set after the
+// fragmentation.
+func (f *fragmenter) setOverhead(overhead int) error {
+	chunkSize := f.mtu - fragHeaderSize - overhead
+	if chunkSize <= 0 {
+		return ErrMTUTooSmall
+	}
+	f.chunkSize = chunkSize
+	return nil
+}
+
+// split returns b as is when it fits a packet, its fragments otherwise.
+func (f *fragmenter) split(b []byte) ([][]byte, error) {
+	if len(b) <= f.chunkSize+fragHeaderSize {
+		return [][]byte{b}, nil
+	}
+	count := (len(b) + f.chunkSize - 1) / f.chunkSize
+	if count > math.MaxUint16 || len(b) > f.maxSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	id := atomic.AddUint32(&f.id, 1)
+	frags := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := b[i*f.chunkSize:]
+		if len(chunk) > f.chunkSize {
+			chunk = chunk[:f.chunkSize]
+		}
+		frag := make([]byte, fragHeaderSize, fragHeaderSize+len(chunk))
+		frag[0] = fragMagic
+		binary.BigEndian.PutUint32(frag[1:], id)
+		binary.BigEndian.PutUint16(frag[5:], uint16(i))
+		binary.BigEndian.PutUint16(frag[7:], uint16(count))
+		frags = append(frags, append(frag, chunk...))
+	}
+	return frags, nil
+}
+
+// add stores a fragment received from src and returns the message once
+// all its fragments arrived.
+func (f *fragmenter) add(src *net.UDPAddr, frag []byte, now time.Time) ([]byte, error) {
+	if len(frag) < fragHeaderSize || frag[0] != fragMagic {
+		return nil, ErrInvalidFragment
+	}
+	id := binary.BigEndian.Uint32(frag[1:])
+	index := int(binary.BigEndian.Uint16(frag[5:]))
+	count := int(binary.BigEndian.Uint16(frag[7:]))
+	data := frag[fragHeaderSize:]
+	if count == 0 || index >= count || len(data) == 0 || count > f.maxSize {
+		return nil, ErrInvalidFragment
+	}
+	key := src.String() + "/" + strconv.FormatUint(uint64(id), 10)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+
+	p, ok := f.partials[key]
+	if !ok {
+		if len(f.partials) >= f.maxPartial {
+			f.evict()
+		}
+		p = &partial{
+			frags: make(map[int][]byte),
+			count: count,
+		}
+		f.partials[key] = p
+	}
+	if p.count != count {
+		delete(f.partials, key)
+		return nil, ErrInvalidFragment
+	}
+	p.expires = now.Add(f.timeout)
+	if _, ok := p.frags[index]; ok {
+		return nil, nil
+	}
+	p.size += len(data)
+	if p.size > f.maxSize {
+		delete(f.partials, key)
+		return nil, ErrMessageTooLarge
+	}
+	p.frags[index] = append([]byte(nil), data...)
+	if len(p.frags) < count {
+		return nil, nil
+	}
+
+	delete(f.partials, key)
+	b := make([]byte, 0, p.size)
+	for i := 0; i < count; i++ {
+		b = append(b, p.frags[i]...)
+	}
+	return b, nil
+}
+
+// expire drops the incomplete messages that timed out.
+func (f *fragmenter) expire(now time.Time) {
+	for key, p := range f.partials {
+		if now.After(p.expires) {
+			delete(f.partials, key)
+		}
+	}
+}
+
+// evict drops the incomplete message that waited longest.
+func (f *fragmenter) evict() {
+	var oldest string
+	var expires time.Time
+	for key, p := range f.partials {
+		if oldest == "" || p.expires.Before(expires) {
+			oldest, expires = key, p.expires
+		}
+	}
+	delete(f.partials, oldest)
+}
@@ -0,0 +1,115 @@
+package multicast
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFragmenter(t *testing.T) {
+	f := newFragmenter(&Fragmentation{MTU: 100 + fragHeaderSize, MaxPartial: 2, MaxSize: 4096})
+	assert.NoError(t, f.setOverhead(0))
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 9999}
+	now := time.Now()
+
+	small := []byte(`{"cmd":"ping"}`)
+	frags, err := f.split(small)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{small}, frags)
+
+	msg := make([]byte, 1050)
+	rand.Read(msg)
+	frags, err = f.split(msg)
+	assert.NoError(t, err)
+	assert.Len(t, frags, 11)
+
+	// out of order, with a duplicate
+	rand.Shuffle(len(frags), func(i, j int) {
+		frags[i], frags[j] = frags[j], frags[i]
+	})
+	for _, frag := range frags[:10] {
+		b, err := f.add(src, frag, now)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	}
+	b, err := f.add(src, frags[0], now)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+	b, err = f.add(src, frags[10], now)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, b)
+
+	// incomplete messages time out or are evicted
+	first, _ := f.split(msg)
+	second, _ := f.split(msg)
+	third, _ := f.split(msg)
+	_, _ = f.add(src, first[0], now)
+	_, _ = f.add(src, second[0], now.Add(time.Second))
+	_, _ = f.add(src, third[0], now.Add(time.Second*2))
+	assert.Len(t, f.partials, 2)
+	_, _ = f.add(src, third[1], now.Add(time.Second*7))
+	assert.Len(t, f.partials, 1)
+
+	_, err = f.split(make([]byte, 5000))
+	assert.Equal(t, ErrMessageTooLarge, err)
+	_, err = f.add(src, []byte{fragMagic, 0, 0}, now)
+	assert.Equal(t, ErrInvalidFragment, err)
+
+	// a spoofed count larger than any message is rejected
+	spoofed := []byte{fragMagic, 0, 0, 0, 1, 0, 0, 0xff, 0xff, 'x'}
+	_, err = f.add(src, spoofed, now)
+	assert.Equal(t, ErrInvalidFragment, err)
+}
+
+func TestFragmentationBeforeSecurity(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.20", 19028, 256)
+	assert.NoError(t, s.SetFragmentation(&Fragmentation{MTU: 256}))
+	assert.NoError(t, s.SetSecurity(&Security{Key: []byte("secret"), EncryptKey: []byte("1234567890ABCDEF")}))
+
+	received := make(chan string, 1)
+	s.OnCmd("upload", func(c *MessageContext) {
+		var text string
+		assert.NoError(t, c.ShouldBindJson(&text))
+		received <- text
+	})
+	startLoopback(t, s)
+	assert.Equal(t, 256-fragHeaderSize-s.security.overhead(), s.fragmenter.chunkSize)
+
+	text := strings.Repeat("0123456789", 300)
+	assert.NoError(t, s.SendJson(&Body{Cmd: "upload", Data: text}))
+	select {
+	case got := <-received:
+		assert.Equal(t, text, got)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestServerFragmentation(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.16", 19018, 256)
+	assert.NoError(t, s.SetSecurity(&Security{Key: []byte("secret"), EncryptKey: []byte("1234567890ABCDEF")}))
+	assert.Error(t, s.SetFragmentation(&Fragmentation{MTU: 1024}))
+	assert.Equal(t, ErrMTUTooSmall, s.SetFragmentation(&Fragmentation{MTU: 64}))
+	assert.NoError(t, s.SetFragmentation(&Fragmentation{MTU: 256}))
+
+	received := make(chan string, 1)
+	s.OnCmd("upload", func(c *MessageContext) {
+		var text string
+		assert.NoError(t, c.ShouldBindJson(&text))
+		received <- text
+	})
+	startLoopback(t, s)
+
+	text := strings.Repeat("0123456789", 300)
+	assert.NoError(t, s.SendJson(&Body{Cmd: "upload", Data: text}))
+	select {
+	case got := <-received:
+		assert.Equal(t, text, got)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
@@ -5,13 +5,18 @@ type Group struct {
 	handlers []Handler
 }
 
+// Use adds middleware to the cmds registered on the group afterwards.
+func (g *Group) Use(middleware ...Handler) {
+	g.handlers = combineHandlers(g.handlers, middleware)
+}
+
 func (g *Group) OnCmd(cmd string, handlers ...Handler) {
-	g.server.routes[cmd] = append(g.handlers, handlers...)
+	g.server.OnCmd(cmd, combineHandlers(g.handlers, handlers)...)
 }
 
 func (g *Group) Group(handlers ...Handler) *Group {
 	return &Group{
 		server:   g.server,
-		handlers: append(g.handlers, handlers...),
+		handlers: combineHandlers(g.handlers, handlers),
 	}
 }
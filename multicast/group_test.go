@@ -0,0 +1,51 @@
+package multicast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/internal/route"
+)
+
+func handlerA(c *MessageContext) {}
+func handlerB(c *MessageContext) {}
+func handlerC(c *MessageContext) {}
+
+func TestGroupChaining(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.1", 9999, 1024)
+	g := s.Group(handlerA, handlerB)
+	g.Group(handlerA).OnCmd("one", handlerC)
+	g.Group(handlerB).OnCmd("two", handlerC)
+	s.Use(handlerC)
+
+	name := func(h Handler) string {
+		return route.FuncName(h)
+	}
+	routes := s.Routes()
+	assert.Equal(t, "one", routes[0].Cmd)
+	assert.Equal(t, []string{name(handlerC), name(handlerA), name(handlerB), name(handlerA), name(handlerC)}, routes[0].Handlers)
+	assert.Equal(t, "two", routes[1].Cmd)
+	assert.Equal(t, []string{name(handlerC), name(handlerA), name(handlerB), name(handlerB), name(handlerC)}, routes[1].Handlers)
+}
+
+func TestTooManyHandlers(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.1", 9999, 1024)
+	handlers := make([]Handler, abortIndex)
+	for i := range handlers {
+		handlers[i] = handlerA
+	}
+	assert.Panics(t, func() {
+		s.OnCmd("many", handlers...)
+	})
+
+	s.OnCmd("few", handlerC)
+	s.OnCmd("many", handlers[1:]...)
+	assert.Panics(t, func() {
+		s.Use(handlerB)
+	})
+	// a failed Use leaves the server unchanged
+	assert.Empty(t, s.middleware)
+	routes := s.Routes()
+	assert.Equal(t, []string{route.FuncName(handlerC)}, routes[0].Handlers)
+	assert.Len(t, routes[1].Handlers, int(abortIndex)-1)
+}
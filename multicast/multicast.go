@@ -5,12 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/xuzq3/glib/internal/route"
 	"github.com/xuzq3/glib/logx"
 	"github.com/xuzq3/glib/util"
-	"golang.org/x/net/ipv4"
 )
 
 type Server struct {
@@ -20,6 +21,10 @@ type Server struct {
 	isLoopback bool
 	buffSize   int
 
+	runMu      sync.Mutex
+	running    bool
+	wg         sync.WaitGroup
+	handling   *sync.WaitGroup
 	ctx        context.Context
 	cancel     context.CancelFunc
 	ticker     *time.Ticker
@@ -28,23 +33,48 @@ type Server struct {
 	msgCtxPool *MessageContextPool
 	groupAddr  *net.UDPAddr
 	conn       *net.UDPConn
-	pconn      *ipv4.PacketConn
+	pconn      packetConn
+	ipv6       bool
 	iface      *net.Interface
-	joinIfaces map[string]struct{}
-	routes     map[string][]Handler
+	multiIface bool
+	selector   InterfaceSelector
+	ifaceMu    sync.RWMutex
+	joinIfaces map[string]*net.Interface
+	pendingMu  sync.Mutex
+	pending    map[string]chan *Response
+	security   *packetSecurity
+	fragmenter *fragmenter
+	reliable   *reliable
+	pool       *WorkerPool
+	dispatcher *dispatcher
+
+	reloadInterval time.Duration
+	ifaceHandlers  []InterfaceHandler
+
+	middleware   []Handler
+	routes       *route.Router
+	noRoute      []Handler
+	noRouteChain []Handler
 }
 
+// NewServer returns a server of the group groupIP. IPv6 is used when
+// groupIP is an IPv6 group such as ff02::/16 or ff05::/16, wildcardIP must
+// then be "::" or empty.
 func NewServer(wildcardIP string, groupIP string, port int, buffSize int) *Server {
 	return &Server{
 		wildcardIP: wildcardIP,
 		groupIP:    groupIP,
 		port:       port,
 		buffSize:   buffSize,
+		selector:   DefaultInterfaceSelector(),
 		once:       make(chan struct{}, 1),
 		bytePool:   NewBytePool(buffSize),
 		msgCtxPool: NewMessageContextPool(),
-		joinIfaces: make(map[string]struct{}),
-		routes:     make(map[string][]Handler),
+		joinIfaces: make(map[string]*net.Interface),
+		pending:    make(map[string]chan *Response),
+		routes:     route.New(),
+
+		reloadInterval: defaultReloadInterval,
 	}
 }
 
@@ -52,7 +82,20 @@ func (s *Server) SetLoopback(en bool) {
 	s.isLoopback = en
 }
 
+// SetMultiInterface makes the server join the group on every valid
+// interface instead of a single one, and send on all of them.
+func (s *Server) SetMultiInterface(en bool) {
+	s.multiIface = en
+}
+
+// Start listens on the port and joins the group. A stopped server can be
+// started again, starting a running server returns ErrServerStarted.
 func (s *Server) Start() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.running {
+		return ErrServerStarted
+	}
 	err := s.init()
 	if err != nil {
 		return err
@@ -62,19 +105,92 @@ func (s *Server) Start() error {
 	//	return err
 	//}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
-	s.ticker = time.NewTicker(time.Second * 30)
-	s.once <- struct{}{}
+	s.ticker = time.NewTicker(s.reloadInterval)
+	select {
+	case s.once <- struct{}{}:
+	default:
+	}
+	s.handling = new(sync.WaitGroup)
+	s.dispatcher = nil
+	if s.pool != nil {
+		s.dispatcher = s.newDispatcher(s.pool)
+	}
+	s.wg.Add(2)
 	go s.loop()
 	go s.recv()
+	if s.reliable != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.reliable.run(s.ctx)
+		}()
+	}
+	s.running = true
 	return nil
 }
 
-func (s *Server) Stop() {
+// Stop is Shutdown without a deadline.
+func (s *Server) Stop() error {
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown stops receiving, waits for the messages being handled or queued
+// in the worker pool until ctx is done, then leaves the group on every
+// interface and closes the socket. Packets still queued when ctx is done
+// are dropped.
+// It returns ErrServerClosed when the server isn't running.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if !s.running {
+		return ErrServerClosed
+	}
+	s.running = false
 	s.ticker.Stop()
 	s.cancel()
+	// unblock ReadFromUDP, the socket is still needed by the handlers
+	_ = s.conn.SetReadDeadline(time.Now())
+	s.wg.Wait()
+	// recv returned, the workers drain the queued packets
+	if s.dispatcher != nil {
+		s.dispatcher.close()
+	}
+
+	done := make(chan struct{})
+	go func(handling *sync.WaitGroup, d *dispatcher) {
+		handling.Wait()
+		if d != nil {
+			d.wg.Wait()
+		}
+		close(done)
+	}(s.handling, s.dispatcher)
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		logx.Errorf("multicast shutdown before the handlers returned: %s", err.Error())
+		if s.dispatcher != nil {
+			s.dispatcher.abort()
+		}
+	}
+
+	s.leaveGroups()
+	if cerr := s.conn.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
 }
 
 func (s *Server) init() error {
+	if s.fragmenter != nil {
+		err := s.fragmenter.setOverhead(s.securityOverhead())
+		if err != nil {
+			return err
+		}
+	}
+
 	groupIP := net.ParseIP(s.groupIP)
 	if isMulticastIp := net.IP.IsMulticast(groupIP); !isMulticastIp {
 		err := fmt.Errorf("ip is not a multicast address: %s", s.groupIP)
@@ -96,13 +212,14 @@ func (s *Server) init() error {
 	}
 	logx.Info("multicast start listen: %s", listenAddress.String())
 
-	conn, err := net.ListenUDP("udp4", listenAddress)
+	conn, err := net.ListenUDP(network(groupIP), listenAddress)
 	if err != nil {
 		logx.Error("multicast listen udp failed: %s", err.Error())
 		return err
 	}
 	s.conn = conn
-	s.pconn = ipv4.NewPacketConn(conn)
+	s.pconn = newPacketConn(conn, groupIP)
+	s.ipv6 = groupIP.To4() == nil
 
 	logx.Info("multicast listen success: %s", listenAddress.String())
 
@@ -110,6 +227,7 @@ func (s *Server) init() error {
 		err = s.pconn.SetMulticastLoopback(true)
 		if err != nil {
 			logx.Error("multicast SetMulticastLoopback failed: %s", err.Error())
+			_ = conn.Close()
 			return err
 		}
 	}
@@ -125,18 +243,22 @@ func (s *Server) init() error {
 func (s *Server) reloadInterface() error {
 	logx.Debug("reload multicast interface")
 	ifaces := s.getValidInterfaces()
+	if s.multiIface {
+		return s.reloadInterfaces(ifaces)
+	}
 
-	// 检查原有的网卡能否继续使用
-	if s.iface != nil {
-		for _, iface := range ifaces {
-			if s.iface.Name == iface.Name {
-				return nil
-			}
+	old := s.iface
+	defer func() {
+		if s.iface != nil && (old == nil || old.Name != s.iface.Name) {
+			s.emitInterface(InterfaceEvent{Old: old, New: s.iface})
 		}
-	}
+	}()
 
-	// 寻找有效网卡
+	// 按优先级寻找有效网卡，原有的网卡仍是首选时继续使用
 	for _, iface := range ifaces {
+		if s.iface != nil && s.iface.Name == iface.Name {
+			return nil
+		}
 		err := s.setInterface(iface)
 		if err != nil {
 			continue
@@ -171,6 +293,8 @@ func (s *Server) setInterface(iface *net.Interface) error {
 		IP: net.ParseIP(s.groupIP),
 	}
 
+	s.ifaceMu.Lock()
+	defer s.ifaceMu.Unlock()
 	if _, ok := s.joinIfaces[iface.Name]; ok {
 		err = s.pconn.LeaveGroup(iface, group)
 		if err != nil {
@@ -183,59 +307,139 @@ func (s *Server) setInterface(iface *net.Interface) error {
 		logx.Error("multicast interface %s join group failed: %s", iface.Name, err.Error())
 		return err
 	}
-	s.joinIfaces[iface.Name] = struct{}{}
+	s.joinIfaces[iface.Name] = iface
 
 	err = s.pconn.SetMulticastInterface(iface)
 	if err != nil {
 		logx.Error("multicast set interface %s failed: %s", iface.Name, err.Error())
+		if s.iface == nil || s.iface.Name != iface.Name {
+			s.leaveInterface(iface, group)
+		}
 		return err
 	}
 
+	// 单网卡模式下只保留新网卡
+	if s.iface != nil && s.iface.Name != iface.Name {
+		s.leaveInterface(s.iface, group)
+	}
 	logx.Info("multicast set interface %s", iface.Name)
 	s.iface = iface
 	return nil
 }
 
-func (s *Server) getValidInterfaces() []*net.Interface {
-	ifaces, err := net.Interfaces()
+// leaveInterface leaves the group on iface, which may be gone already. It
+// must be called with ifaceMu held.
+func (s *Server) leaveInterface(iface *net.Interface, group net.Addr) {
+	err := s.pconn.LeaveGroup(iface, group)
 	if err != nil {
-		logx.Error("multicast net.Interfaces failed: %s", err.Error())
-		return nil
+		logx.Error("multicast interface %s leave group failed: %s", iface.Name, err.Error())
 	}
+	delete(s.joinIfaces, iface.Name)
+}
 
-	list := make([]*net.Interface, 0)
-	for i := range ifaces {
-		iface := ifaces[i]
+// reloadInterfaces joins the group on every valid interface and leaves it
+// on the interfaces that went away. The loopback interface is joined when
+// no other interface is valid.
+func (s *Server) reloadInterfaces(ifaces []*net.Interface) error {
+	if len(ifaces) == 0 {
+		iface, err := util.GetLoopbackInterface()
+		if err != nil {
+			logx.Errorf("multicast GetLoopbackInterface failed: %s", err.Error())
+			return err
+		}
+		if iface != nil {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	valid := make(map[string]*net.Interface, len(ifaces))
+	for _, iface := range ifaces {
+		valid[iface.Name] = iface
+	}
+	group := &net.UDPAddr{
+		IP: s.groupAddr.IP,
+	}
 
-		// 过滤无效地址
-		if iface.Flags&net.FlagUp == 0 {
-			continue
+	var events []InterfaceEvent
+	defer func() {
+		for _, e := range events {
+			s.emitInterface(e)
 		}
-		// 过滤环回地址
-		if iface.Flags&net.FlagLoopback != 0 {
+	}()
+
+	s.ifaceMu.Lock()
+	defer s.ifaceMu.Unlock()
+	for name, iface := range s.joinIfaces {
+		if _, ok := valid[name]; ok {
 			continue
 		}
-		// 过滤非多播地址
-		if iface.Flags&net.FlagMulticast == 0 {
-			continue
+		// the interface may be gone already
+		err := s.pconn.LeaveGroup(iface, group)
+		if err != nil {
+			logx.Errorf("multicast interface %s leave group failed: %s", name, err.Error())
 		}
-		// 过滤虚拟地址
-		name := iface.Name
-		name = strings.ToLower(name)
-		if strings.Contains(name, "vmnet") {
+		delete(s.joinIfaces, name)
+		events = append(events, InterfaceEvent{Old: iface})
+		logx.Infof("multicast interface %s left group", name)
+	}
+	for name, iface := range valid {
+		if _, ok := s.joinIfaces[name]; ok {
 			continue
 		}
-		// 过滤获取不了IP或者IP为保留地址169.254.x.x的网卡
-		ip := util.GetInterfaceIPv4(&iface)
-		if ip == "" || strings.HasPrefix(ip, "169.254.") {
+		err := s.pconn.JoinGroup(iface, group)
+		if err != nil {
+			logx.Errorf("multicast interface %s join group failed: %s", name, err.Error())
 			continue
 		}
-		list = append(list, &iface)
+		s.joinIfaces[name] = iface
+		events = append(events, InterfaceEvent{New: iface})
+		logx.Infof("multicast interface %s joined group", name)
+	}
+	return nil
+}
+
+// leaveGroups leaves the group on every joined interface.
+func (s *Server) leaveGroups() {
+	group := &net.UDPAddr{
+		IP: s.groupAddr.IP,
+	}
+
+	s.ifaceMu.Lock()
+	defer s.ifaceMu.Unlock()
+	for name, iface := range s.joinIfaces {
+		err := s.pconn.LeaveGroup(iface, group)
+		if err != nil {
+			logx.Errorf("multicast interface %s leave group failed: %s", name, err.Error())
+		}
+		delete(s.joinIfaces, name)
+	}
+	s.iface = nil
+}
+
+// Interfaces returns the names of the interfaces that joined the group.
+func (s *Server) Interfaces() []string {
+	s.ifaceMu.RLock()
+	defer s.ifaceMu.RUnlock()
+	names := make([]string, 0, len(s.joinIfaces))
+	for name := range s.joinIfaces {
+		names = append(names, name)
 	}
-	return list
+	sort.Strings(names)
+	return names
+}
+
+// getValidInterfaces returns the interfaces picked by the selector, the
+// preferred first.
+func (s *Server) getValidInterfaces() []*net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logx.Error("multicast net.Interfaces failed: %s", err.Error())
+		return nil
+	}
+	return s.selector.Select(ifaces, s.ipv6)
 }
 
 func (s *Server) loop() {
+	defer s.wg.Done()
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -255,11 +459,94 @@ func (s *Server) Send(b []byte) error {
 		logx.Error("multicast Send failed: %s", err.Error())
 		return err
 	}
-	_, err := s.pconn.WriteTo(b, nil, s.groupAddr)
+	packets, err := s.pack(b)
 	if err != nil {
-		logx.Error("multicast Send failed: %s", err.Error())
+		logx.Errorf("multicast pack failed: %s", err.Error())
+		return err
+	}
+	if s.multiIface {
+		if ifaces := s.Interfaces(); len(ifaces) > 0 {
+			return s.sendOn(packets, ifaces)
+		}
+	}
+	for _, packet := range packets {
+		_, err = s.pconn.WriteToInterface(packet, 0, s.groupAddr)
+		if err != nil {
+			logx.Error("multicast Send failed: %s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// SendOn sends b on the named interfaces, which must have joined the
+// group. When some of them fail a *SendError is returned, the message was
+// sent on the others.
+func (s *Server) SendOn(b []byte, ifaces ...string) error {
+	if s.pconn == nil {
+		err := fmt.Errorf("udp was unconnected")
+		logx.Errorf("multicast SendOn failed: %s", err.Error())
 		return err
 	}
+	packets, err := s.pack(b)
+	if err != nil {
+		logx.Errorf("multicast pack failed: %s", err.Error())
+		return err
+	}
+	return s.sendOn(packets, ifaces)
+}
+
+// pack splits b into fragments and seals them, according to the
+// configuration of the server.
+func (s *Server) pack(b []byte) ([][]byte, error) {
+	packets := [][]byte{b}
+	if s.fragmenter != nil {
+		var err error
+		packets, err = s.fragmenter.split(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.security != nil {
+		now := time.Now()
+		for i, packet := range packets {
+			sealed, err := s.security.seal(packet, now)
+			if err != nil {
+				return nil, err
+			}
+			packets[i] = sealed
+		}
+	}
+	return packets, nil
+}
+
+func (s *Server) sendOn(packets [][]byte, ifaces []string) error {
+	var errs map[string]error
+	for _, name := range ifaces {
+		s.ifaceMu.RLock()
+		iface, ok := s.joinIfaces[name]
+		s.ifaceMu.RUnlock()
+
+		err := ErrUnknownInterface
+		if ok {
+			for _, packet := range packets {
+				_, err = s.pconn.WriteToInterface(packet, iface.Index, s.groupAddr)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logx.Errorf("multicast Send on interface %s failed: %s", name, err.Error())
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[name] = err
+		}
+	}
+	if errs != nil {
+		return &SendError{Errors: errs}
+	}
 	return nil
 }
 
@@ -272,6 +559,8 @@ func (s *Server) SendJson(v interface{}) error {
 }
 
 func (s *Server) recv() {
+	defer s.wg.Done()
+	handling := s.handling
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -282,7 +571,11 @@ func (s *Server) recv() {
 		b := s.bytePool.Get()
 		n, src, err := s.conn.ReadFromUDP(b)
 		if err != nil {
-			logx.Error("multicast ReadFrom failed: %s", err.Error())
+			s.bytePool.Put(b)
+			if s.ctx.Err() != nil {
+				return
+			}
+			logx.Errorf("multicast ReadFrom failed: %s", err.Error())
 			continue
 		}
 		//if !cm.Dst.IsMulticast() || !cm.Dst.Equal(s.groupAddr.IP) {
@@ -290,7 +583,15 @@ func (s *Server) recv() {
 		//	continue
 		//}
 
-		go s.handle(n, src, b)
+		if s.dispatcher != nil {
+			s.dispatcher.dispatch(s.ctx, &packet{n: n, src: src, b: b})
+		} else {
+			handling.Add(1)
+			go func() {
+				defer handling.Done()
+				s.handle(n, src, b)
+			}()
+		}
 	}
 }
 
@@ -304,7 +605,55 @@ func (s *Server) handle(n int, src *net.UDPAddr, b []byte) {
 
 	msg := b[:n]
 	//logx.Debug("multicast recv %s %s", src.String(), string(msg))
-	ctx, err := s.parseMessage(n, src, msg)
+	if s.security != nil {
+		var err error
+		msg, err = s.security.open(msg, time.Now())
+		if err != nil {
+			logx.Debugf("multicast drop packet from %s: %s", src.String(), err.Error())
+			return
+		}
+	}
+	if len(msg) > 0 && msg[0] == fragMagic {
+		if s.fragmenter == nil {
+			logx.Debugf("multicast drop fragment from %s", src.String())
+			return
+		}
+		var err error
+		msg, err = s.fragmenter.add(src, msg, time.Now())
+		if err != nil {
+			logx.Errorf("multicast drop fragment from %s: %s", src.String(), err.Error())
+			return
+		}
+		if msg == nil {
+			return
+		}
+	}
+	if len(msg) > 0 && msg[0] >= relData && msg[0] <= relHeartbeat {
+		if s.reliable == nil {
+			logx.Debugf("multicast drop reliable frame from %s", src.String())
+			return
+		}
+		var err error
+		msg, err = s.reliable.receive(src, msg, time.Now())
+		if err != nil {
+			logx.Errorf("multicast drop reliable frame from %s: %s", src.String(), err.Error())
+			return
+		}
+		if msg == nil {
+			return
+		}
+	}
+	var body MessageBody
+	err := json.Unmarshal(msg, &body)
+	if err != nil {
+		logx.Error("multicast parse message failed, msg:%s, err:%s", string(msg), err.Error())
+		return
+	}
+	// response to a Request of this server
+	if s.resolve(src, &body) {
+		return
+	}
+	ctx, err := s.parseMessage(len(msg), src, msg, &body)
 	if err != nil {
 		logx.Error("multicast parse message failed, msg:%s, err:%s", string(msg), err.Error())
 		return
@@ -313,14 +662,13 @@ func (s *Server) handle(n int, src *net.UDPAddr, b []byte) {
 	ctx.Next()
 }
 
-func (s *Server) parseMessage(n int, src *net.UDPAddr, b []byte) (*MessageContext, error) {
-	var body MessageBody
-	err := json.Unmarshal(b, &body)
-	if err != nil {
-		return nil, err
-	}
-	handlers, ok := s.routes[body.Cmd]
-	if !ok {
+func (s *Server) parseMessage(n int, src *net.UDPAddr, b []byte, body *MessageBody) (*MessageContext, error) {
+	var handlers []Handler
+	if value, ok := s.routes.Match(body.Cmd); ok {
+		handlers = value.(*routeEntry).chain
+	} else if len(s.noRouteChain) > 0 {
+		handlers = s.noRouteChain
+	} else {
 		return nil, ErrUnknownCmd
 	}
 
@@ -333,19 +681,114 @@ func (s *Server) parseMessage(n int, src *net.UDPAddr, b []byte) (*MessageContex
 	ctx := s.msgCtxPool.Get()
 	ctx.reset()
 	ctx.Message = msg
-	ctx.Body = &body
+	ctx.Body = body
 	ctx.Server = s
 	ctx.handlers = handlers
 	return ctx, nil
 }
 
+// routeEntry keeps the handlers registered for a route together with the
+// chain served, which is prefixed by the global middleware.
+type routeEntry struct {
+	handlers []Handler
+	chain    []Handler
+}
+
+// Use adds global middleware running before the handlers of every cmd,
+// including the cmds registered before and the NoRoute handlers.
+func (s *Server) Use(middleware ...Handler) {
+	all := combineHandlers(s.middleware, middleware)
+	// build every chain before updating anything, as chain may panic
+	routes := s.routes.Routes()
+	chains := make([][]Handler, len(routes))
+	for i, r := range routes {
+		chains[i] = buildChain(all, r.Pattern, r.Value.(*routeEntry).handlers)
+	}
+	noRouteChain := buildChain(all, "NoRoute", s.noRoute)
+
+	s.middleware = all
+	for i, r := range routes {
+		r.Value.(*routeEntry).chain = chains[i]
+	}
+	s.noRouteChain = noRouteChain
+}
+
+// OnCmd registers handlers for cmd, replacing the handlers of a previous
+// registration. Besides exact cmds, "*" matches one dot-separated segment
+// and a trailing "**" the remaining segments, e.g. "device.*" or
+// "device.**". Exact cmds win over patterns.
 func (s *Server) OnCmd(cmd string, handlers ...Handler) {
-	s.routes[cmd] = handlers
+	handlers = combineHandlers(nil, handlers)
+	s.routes.Add(cmd, &routeEntry{
+		handlers: handlers,
+		chain:    s.chain(cmd, handlers),
+	})
+}
+
+// NoRoute sets the handlers of messages whose cmd matches no route.
+// Without them such messages are dropped.
+func (s *Server) NoRoute(handlers ...Handler) {
+	handlers = combineHandlers(nil, handlers)
+	s.noRouteChain = s.chain("NoRoute", handlers)
+	s.noRoute = handlers
+}
+
+// chain prefixes handlers with the global middleware. It panics when the
+// chain is too long to be served.
+func (s *Server) chain(cmd string, handlers []Handler) []Handler {
+	return buildChain(s.middleware, cmd, handlers)
+}
+
+// buildChain prefixes handlers with middleware and panics when the result is
+// too long to be served.
+func buildChain(middleware []Handler, cmd string, handlers []Handler) []Handler {
+	if len(handlers) == 0 {
+		return nil
+	}
+	merged := combineHandlers(middleware, handlers)
+	if len(merged) >= int(abortIndex) {
+		panic(fmt.Sprintf("multicast: too many handlers for cmd %q: %d, the limit is %d", cmd, len(merged), abortIndex-1))
+	}
+	return merged
+}
+
+// combineHandlers returns a new slice holding a followed by b, so that
+// chains built from a common prefix never share their backing array.
+func combineHandlers(a, b []Handler) []Handler {
+	merged := make([]Handler, len(a)+len(b))
+	copy(merged, a)
+	copy(merged[len(a):], b)
+	return merged
+}
+
+// RouteInfo describes a registered route and the names of its handlers,
+// global and group middleware first.
+type RouteInfo struct {
+	Cmd      string
+	Handlers []string
+}
+
+// Routes returns the registered routes in registration order.
+func (s *Server) Routes() []RouteInfo {
+	routes := s.routes.Routes()
+	infos := make([]RouteInfo, 0, len(routes))
+	for _, r := range routes {
+		chain := r.Value.(*routeEntry).chain
+		names := make([]string, 0, len(chain))
+		for _, handler := range chain {
+			names = append(names, route.FuncName(handler))
+		}
+		infos = append(infos, RouteInfo{
+			Cmd:      r.Pattern,
+			Handlers: names,
+		})
+	}
+	return infos
 }
 
 func (s *Server) Group(handlers ...Handler) *Group {
 	return &Group{
 		server:   s,
-		handlers: handlers,
+		handlers: combineHandlers(nil, handlers),
 	}
 }
@@ -0,0 +1,171 @@
+package multicast
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/util"
+)
+
+func TestMultiInterface(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.11", 19011, 1024)
+	s.SetLoopback(true)
+	s.SetMultiInterface(true)
+	received := make(chan string, 4)
+	s.OnCmd("ping", func(c *MessageContext) {
+		received <- c.Body.Seqno
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(s.Interfaces()) > 0
+	}, time.Second, time.Millisecond*10)
+
+	err := s.SendJson(&Body{Cmd: "ping", Seqno: "1"})
+	assert.NoError(t, err)
+	select {
+	case seqno := <-received:
+		assert.Equal(t, "1", seqno)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	err = s.SendOn([]byte(`{"cmd":"ping"}`), "unknown0")
+	if assert.IsType(t, &SendError{}, err) {
+		assert.Equal(t, ErrUnknownInterface, err.(*SendError).Errors["unknown0"])
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.13", 19013, 1024)
+	s.SetLoopback(true)
+	s.SetMultiInterface(true)
+	entered := make(chan string, 4)
+	release := make(chan struct{})
+	s.OnCmd("ping", func(c *MessageContext) {
+		entered <- c.Body.Seqno
+		<-release
+	})
+	assert.Equal(t, ErrServerClosed, s.Stop())
+
+	for _, seqno := range []string{"1", "2"} {
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, ErrServerStarted, s.Start())
+		assert.Eventually(t, func() bool {
+			return len(s.Interfaces()) > 0
+		}, time.Second, time.Millisecond*10)
+
+		assert.NoError(t, s.SendJson(&Body{Cmd: "ping", Seqno: seqno}))
+		select {
+		case got := <-entered:
+			assert.Equal(t, seqno, got)
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		err := s.Shutdown(ctx)
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Empty(t, s.Interfaces())
+		release <- struct{}{}
+		assert.Equal(t, ErrServerClosed, s.Stop())
+	}
+}
+
+// startLoopback serves s on the loopback interface only.
+func startLoopback(t *testing.T, s *Server) {
+	lo, err := util.GetLoopbackInterface()
+	if err != nil || lo == nil {
+		t.Skip("no loopback interface")
+	}
+	s.SetLoopback(true)
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setInterface(lo); err != nil {
+		t.Skipf("multicast on loopback unsupported: %v", err)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.ticker = time.NewTicker(time.Hour)
+	s.handling = new(sync.WaitGroup)
+	s.running = true
+	s.wg.Add(1)
+	go s.recv()
+	t.Cleanup(func() {
+		_ = s.Stop()
+	})
+}
+
+func TestLoopback(t *testing.T) {
+	tests := []struct {
+		name  string
+		group string
+	}{
+		{"ipv4", "239.0.0.12"},
+		{"ipv6 link-local", "ff02::114"},
+		{"ipv6 site-local", "ff05::114"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("", tt.group, 19012+i, 1024)
+			received := make(chan string, 1)
+			s.Use(Recovery())
+			s.OnCmd("device.*", func(c *MessageContext) {
+				received <- c.Body.Cmd
+			})
+			startLoopback(t, s)
+			assert.Equal(t, tt.group != "239.0.0.12", s.ipv6)
+
+			err := s.SendJson(&Body{Cmd: "device.ping"})
+			if errors.Is(err, syscall.ENETUNREACH) {
+				// some kernels have no multicast route on the loopback
+				t.Skipf("multicast on loopback unsupported: %v", err)
+			}
+			assert.NoError(t, err)
+			select {
+			case cmd := <-received:
+				assert.Equal(t, "device.ping", cmd)
+			case <-time.After(time.Second):
+				t.Fatal("message not received")
+			}
+		})
+	}
+}
+
+func TestNoRoute(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.1", 9999, 1024)
+	routed := make(chan string, 2)
+	s.OnCmd("device.*", func(c *MessageContext) {
+		routed <- "device:" + c.Body.Cmd
+	})
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 9999}
+	handle := func(msg string) {
+		b := s.bytePool.Get()
+		n := copy(b, msg)
+		s.handle(n, src, b)
+	}
+
+	// dropped without NoRoute handlers
+	handle(`{"cmd":"unknown"}`)
+	assert.Empty(t, routed)
+
+	s.NoRoute(func(c *MessageContext) {
+		routed <- "noroute:" + c.Body.Cmd
+	})
+	handle(`{"cmd":"device.ping"}`)
+	handle(`{"cmd":"unknown"}`)
+	assert.Equal(t, "device:device.ping", <-routed)
+	assert.Equal(t, "noroute:unknown", <-routed)
+}
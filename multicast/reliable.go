@@ -0,0 +1,386 @@
+package multicast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xuzq3/glib/logx"
+)
+
+// Frames of the reliable channel are laid out as
+//
+//	kind | sender id (8 bytes) | body
+//
+// where the body is the sequence number and the message for a data frame,
+// the last sequence number sent for a heartbeat, and the missing sequence
+// numbers for a nack. Like fragments, their first byte can't start a json
+// message.
+const (
+	relData      = 0xf2
+	relNack      = 0xf3
+	relHeartbeat = 0xf4
+	relIDSize    = 1 + 8
+	relSeqSize   = 8
+	relMaxNack   = 128
+
+	defaultRelWindow            = 1024
+	defaultRelNackInterval      = time.Millisecond * 200
+	defaultRelMaxNacks          = 10
+	defaultRelHeartbeatInterval = time.Second
+)
+
+var (
+	ErrReliableDisabled = errors.New("reliable delivery disabled")
+	ErrInvalidFrame     = errors.New("invalid reliable frame")
+)
+
+// Reliability configures the reliable channel of a server. Messages sent
+// with SendReliable carry a sequence number per sender; receivers detect
+// the gaps, request the missing messages with NACKs and drop duplicates,
+// so every message is handled once as long as the sender still holds it.
+// A receiver hearing a sender for the first time also requests its
+// earlier messages, unless the sender already sent more than a window.
+type Reliability struct {
+	// Window is the number of messages kept by the sender for
+	// retransmission, 1024 by default.
+	Window int
+	// NackInterval is the delay between two NACKs of a missing message,
+	// 200ms by default.
+	NackInterval time.Duration
+	// MaxNacks is the number of NACKs sent for a missing message before it
+	// is given up, 10 by default.
+	MaxNacks int
+	// HeartbeatInterval is the period at which the sender announces its
+	// last sequence number, so that the loss of the last messages is
+	// detected. 1s by default.
+	HeartbeatInterval time.Duration
+}
+
+// SetReliable enables the reliable channel. It must be called before
+// Start, nil disables it.
+func (s *Server) SetReliable(r *Reliability) {
+	if r == nil {
+		s.reliable = nil
+		return
+	}
+	s.reliable = newReliable(s, r)
+}
+
+// SendReliable multicasts b on the reliable channel.
+func (s *Server) SendReliable(b []byte) error {
+	if s.reliable == nil {
+		return ErrReliableDisabled
+	}
+	return s.reliable.send(b)
+}
+
+func (s *Server) SendJsonReliable(v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.SendReliable(js)
+}
+
+type missing struct {
+	due   time.Time
+	nacks int
+}
+
+// peerState is what a receiver knows about the messages of a sender.
+type peerState struct {
+	src  *net.UDPAddr
+	seen time.Time
+	// next is the lowest sequence number neither received nor given up.
+	next     uint64
+	received map[uint64]struct{}
+	missing  map[uint64]*missing
+}
+
+type reliable struct {
+	server            *Server
+	id                uint64
+	window            int
+	nackInterval      time.Duration
+	maxNacks          int
+	heartbeatInterval time.Duration
+
+	mu        sync.Mutex
+	seq       uint64
+	sent      map[uint64][]byte
+	heartbeat time.Time
+	peers     map[uint64]*peerState
+}
+
+func newReliable(s *Server, r *Reliability) *reliable {
+	// the id tells apart the senders sharing an address, across restarts
+	var id [8]byte
+	_, _ = io.ReadFull(rand.Reader, id[:])
+	rl := &reliable{
+		server:            s,
+		id:                binary.BigEndian.Uint64(id[:]),
+		window:            r.Window,
+		nackInterval:      r.NackInterval,
+		maxNacks:          r.MaxNacks,
+		heartbeatInterval: r.HeartbeatInterval,
+		sent:              make(map[uint64][]byte),
+		peers:             make(map[uint64]*peerState),
+	}
+	if rl.window <= 0 {
+		rl.window = defaultRelWindow
+	}
+	if rl.nackInterval <= 0 {
+		rl.nackInterval = defaultRelNackInterval
+	}
+	if rl.maxNacks <= 0 {
+		rl.maxNacks = defaultRelMaxNacks
+	}
+	if rl.heartbeatInterval <= 0 {
+		rl.heartbeatInterval = defaultRelHeartbeatInterval
+	}
+	return rl
+}
+
+func (r *reliable) frame(kind byte, size int) []byte {
+	b := make([]byte, relIDSize, relIDSize+size)
+	b[0] = kind
+	binary.BigEndian.PutUint64(b[1:], r.id)
+	return b
+}
+
+func (r *reliable) send(b []byte) error {
+	r.mu.Lock()
+	r.seq++
+	frame := r.frame(relData, relSeqSize+len(b))
+	frame = appendUint64(frame, r.seq)
+	frame = append(frame, b...)
+	r.sent[r.seq] = frame
+	if r.seq > uint64(r.window) {
+		delete(r.sent, r.seq-uint64(r.window))
+	}
+	r.mu.Unlock()
+	return r.server.Send(frame)
+}
+
+// run sends the NACKs and heartbeats until ctx is done.
+func (r *reliable) run(ctx context.Context) {
+	ticker := time.NewTicker(r.nackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.tick(now)
+		}
+	}
+}
+
+func (r *reliable) tick(now time.Time) {
+	type nack struct {
+		src   *net.UDPAddr
+		frame []byte
+	}
+	var nacks []nack
+	var heartbeat []byte
+
+	r.mu.Lock()
+	if r.seq > 0 && now.Sub(r.heartbeat) >= r.heartbeatInterval {
+		heartbeat = appendUint64(r.frame(relHeartbeat, relSeqSize), r.seq)
+		r.heartbeat = now
+	}
+	for id, p := range r.peers {
+		// the sender stopped or restarted with another id
+		if now.Sub(p.seen) > r.heartbeatInterval*10 {
+			delete(r.peers, id)
+			continue
+		}
+		var frame []byte
+		for seq, m := range p.missing {
+			if now.Before(m.due) {
+				continue
+			}
+			if m.nacks >= r.maxNacks {
+				logx.Errorf("multicast reliable give up seq:%d from %s", seq, p.src.String())
+				delete(p.missing, seq)
+				p.received[seq] = struct{}{}
+				continue
+			}
+			m.nacks++
+			m.due = now.Add(r.nackInterval)
+			if frame == nil {
+				frame = make([]byte, relIDSize, relIDSize+relMaxNack*relSeqSize)
+				frame[0] = relNack
+				binary.BigEndian.PutUint64(frame[1:], id)
+			}
+			frame = appendUint64(frame, seq)
+			if len(frame) == cap(frame) {
+				nacks = append(nacks, nack{src: p.src, frame: frame})
+				frame = nil
+			}
+		}
+		if frame != nil {
+			nacks = append(nacks, nack{src: p.src, frame: frame})
+		}
+		p.advance()
+	}
+	r.mu.Unlock()
+
+	if heartbeat != nil {
+		if err := r.server.Send(heartbeat); err != nil {
+			logx.Errorf("multicast reliable heartbeat failed: %s", err.Error())
+		}
+	}
+	for _, n := range nacks {
+		if err := r.server.unicast(n.frame, n.src); err != nil {
+			logx.Errorf("multicast reliable nack to %s failed: %s", n.src.String(), err.Error())
+		}
+	}
+}
+
+// receive handles a frame of the reliable channel and returns the message
+// to route, if any.
+func (r *reliable) receive(src *net.UDPAddr, frame []byte, now time.Time) ([]byte, error) {
+	if len(frame) < relIDSize {
+		return nil, ErrInvalidFrame
+	}
+	kind := frame[0]
+	id := binary.BigEndian.Uint64(frame[1:])
+	body := frame[relIDSize:]
+
+	switch kind {
+	case relData:
+		if len(body) < relSeqSize {
+			return nil, ErrInvalidFrame
+		}
+		seq := binary.BigEndian.Uint64(body)
+		// frames are handled concurrently, so the first one seen from a
+		// young sender may not be its first one
+		start := seq
+		if seq <= uint64(r.window) {
+			start = 1
+		}
+		r.mu.Lock()
+		fresh := r.peer(id, src, start, now).receive(seq, now, r.window)
+		r.mu.Unlock()
+		if !fresh {
+			return nil, nil
+		}
+		return body[relSeqSize:], nil
+	case relHeartbeat:
+		if len(body) < relSeqSize {
+			return nil, ErrInvalidFrame
+		}
+		last := binary.BigEndian.Uint64(body)
+		r.mu.Lock()
+		r.peer(id, src, last+1, now).expect(last, now, r.window)
+		r.mu.Unlock()
+		return nil, nil
+	case relNack:
+		if id != r.id || len(body)%relSeqSize != 0 {
+			return nil, nil
+		}
+		r.retransmit(body)
+		return nil, nil
+	default:
+		return nil, ErrInvalidFrame
+	}
+}
+
+// peer returns the state of sender id, expecting seq first from a new
+// sender.
+func (r *reliable) peer(id uint64, src *net.UDPAddr, seq uint64, now time.Time) *peerState {
+	p, ok := r.peers[id]
+	if !ok {
+		p = &peerState{
+			next:     seq,
+			received: make(map[uint64]struct{}),
+			missing:  make(map[uint64]*missing),
+		}
+		r.peers[id] = p
+	}
+	p.src = src
+	p.seen = now
+	return p
+}
+
+func (r *reliable) retransmit(seqs []byte) {
+	var frames [][]byte
+	r.mu.Lock()
+	for i := 0; i < len(seqs); i += relSeqSize {
+		if frame, ok := r.sent[binary.BigEndian.Uint64(seqs[i:])]; ok {
+			frames = append(frames, frame)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, frame := range frames {
+		if err := r.server.Send(frame); err != nil {
+			logx.Errorf("multicast reliable retransmit failed: %s", err.Error())
+		}
+	}
+}
+
+// receive records seq and reports whether it wasn't received before.
+func (p *peerState) receive(seq uint64, now time.Time, window int) bool {
+	if seq < p.next {
+		return false
+	}
+	if _, ok := p.received[seq]; ok {
+		return false
+	}
+	if seq > 0 {
+		p.expect(seq-1, now, window)
+	}
+	delete(p.missing, seq)
+	p.received[seq] = struct{}{}
+	p.advance()
+	return true
+}
+
+// expect marks the messages up to last that weren't received as missing.
+// A gap wider than the window can't be recovered, it is skipped.
+func (p *peerState) expect(last uint64, now time.Time, window int) {
+	if last < p.next {
+		return
+	}
+	if last-p.next >= uint64(window) {
+		logx.Errorf("multicast reliable skip seq:%d-%d from %s", p.next, last, p.src.String())
+		p.next = last + 1
+		p.received = make(map[uint64]struct{})
+		p.missing = make(map[uint64]*missing)
+		return
+	}
+	for seq := p.next; seq <= last; seq++ {
+		if _, ok := p.received[seq]; ok {
+			continue
+		}
+		if _, ok := p.missing[seq]; ok {
+			continue
+		}
+		p.missing[seq] = &missing{due: now}
+	}
+}
+
+func (p *peerState) advance() {
+	for {
+		if _, ok := p.received[p.next]; !ok {
+			return
+		}
+		delete(p.received, p.next)
+		p.next++
+	}
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,87 @@
+package multicast
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerState(t *testing.T) {
+	now := time.Now()
+	p := &peerState{
+		src:      &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 9999},
+		next:     1,
+		received: make(map[uint64]struct{}),
+		missing:  make(map[uint64]*missing),
+	}
+	assert.True(t, p.receive(1, now, 16))
+	assert.False(t, p.receive(1, now, 16))
+	assert.True(t, p.receive(4, now, 16))
+	assert.Len(t, p.missing, 2)
+	assert.Equal(t, uint64(2), p.next)
+
+	assert.True(t, p.receive(2, now, 16))
+	assert.True(t, p.receive(3, now, 16))
+	assert.Empty(t, p.missing)
+	assert.Equal(t, uint64(5), p.next)
+	assert.False(t, p.receive(4, now, 16))
+
+	// a heartbeat reveals the lost tail
+	p.expect(6, now, 16)
+	assert.Len(t, p.missing, 2)
+
+	// a gap beyond the window is skipped
+	assert.True(t, p.receive(100, now, 16))
+	assert.Empty(t, p.missing)
+	assert.Equal(t, uint64(101), p.next)
+}
+
+func TestServerReliable(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.17", 19019, 1024)
+	s.SetReliable(&Reliability{NackInterval: time.Millisecond * 20, HeartbeatInterval: time.Millisecond * 50})
+	received := make(chan string, 8)
+	s.OnCmd("config", func(c *MessageContext) {
+		received <- c.Body.Seqno
+	})
+	startLoopback(t, s)
+	go s.reliable.run(s.ctx)
+
+	assert.NoError(t, s.SendJsonReliable(&Body{Cmd: "config", Seqno: "1"}))
+	// the second message is lost on the wire but kept for retransmission
+	r := s.reliable
+	r.mu.Lock()
+	r.seq++
+	lost := appendUint64(r.frame(relData, 0), r.seq)
+	r.sent[r.seq] = append(lost, `{"cmd":"config","seqno":"2"}`...)
+	r.mu.Unlock()
+	assert.NoError(t, s.SendJsonReliable(&Body{Cmd: "config", Seqno: "3"}))
+	// the last message is lost too, the heartbeat reveals it
+	r.mu.Lock()
+	r.seq++
+	lost = appendUint64(r.frame(relData, 0), r.seq)
+	r.sent[r.seq] = append(lost, `{"cmd":"config","seqno":"4"}`...)
+	r.mu.Unlock()
+
+	var seqnos []string
+	for len(seqnos) < 4 {
+		select {
+		case seqno := <-received:
+			seqnos = append(seqnos, seqno)
+		case <-time.After(time.Second):
+			t.Fatalf("messages not received, got %v", seqnos)
+		}
+	}
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, seqnos)
+
+	// duplicates are dropped
+	assert.NoError(t, s.Send(r.sent[1]))
+	select {
+	case seqno := <-received:
+		t.Fatalf("duplicate message %s", seqno)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	assert.Equal(t, ErrReliableDisabled, NewServer("0.0.0.0", "239.0.0.17", 19019, 1024).SendReliable(nil))
+}
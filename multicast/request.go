@@ -0,0 +1,108 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/xuzq3/glib/logx"
+)
+
+// Response is a reply to Request received from one peer.
+type Response struct {
+	Src  *net.UDPAddr
+	Body *MessageBody
+}
+
+// Request multicasts cmd and collects the replies of the peers until ctx
+// is done, so ctx should carry a timeout. Peers answer with
+// MessageContext.Reply. The responses are returned in arrival order.
+func (s *Server) Request(ctx context.Context, cmd string, data interface{}) ([]*Response, error) {
+	seqno := NewSeqno()
+	ch := make(chan *Response, 16)
+
+	s.pendingMu.Lock()
+	s.pending[seqno] = ch
+	s.pendingMu.Unlock()
+
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, seqno)
+		s.pendingMu.Unlock()
+	}()
+
+	err := s.SendJson(&Body{
+		Cmd:   cmd,
+		Seqno: seqno,
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resps []*Response
+	for {
+		select {
+		case resp := <-ch:
+			resps = append(resps, resp)
+		case <-ctx.Done():
+			return resps, nil
+		}
+	}
+}
+
+// resolve delivers a reply to the Request waiting for its seqno and
+// reports whether body was a reply. Replies arriving after their Request
+// returned are dropped, they are never routed to handlers.
+func (s *Server) resolve(src *net.UDPAddr, body *MessageBody) bool {
+	if !body.Reply {
+		return false
+	}
+
+	s.pendingMu.Lock()
+	ch, ok := s.pending[body.Seqno]
+	s.pendingMu.Unlock()
+	if !ok {
+		logx.Debugf("multicast drop late response cmd:%s seq:%s from %s", body.Cmd, body.Seqno, src.String())
+		return true
+	}
+
+	select {
+	case ch <- &Response{Src: src, Body: body}:
+	default:
+		logx.Errorf("multicast drop response cmd:%s seq:%s from %s", body.Cmd, body.Seqno, src.String())
+	}
+	return true
+}
+
+// sendTo unicasts v as json to addr.
+func (s *Server) sendTo(v interface{}, addr *net.UDPAddr) error {
+	if s.conn == nil {
+		err := fmt.Errorf("udp was unconnected")
+		logx.Errorf("multicast sendTo failed: %s", err.Error())
+		return err
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.unicast(js, addr)
+}
+
+// unicast sends b to addr.
+func (s *Server) unicast(b []byte, addr *net.UDPAddr) error {
+	packets, err := s.pack(b)
+	if err != nil {
+		logx.Errorf("multicast pack failed: %s", err.Error())
+		return err
+	}
+	for _, packet := range packets {
+		_, err = s.conn.WriteToUDP(packet, addr)
+		if err != nil {
+			logx.Errorf("multicast sendTo %s failed: %s", addr.String(), err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package multicast
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.13", 19015, 1024)
+	s.OnCmd("whois", func(c *MessageContext) {
+		var name string
+		if err := c.ShouldBindJson(&name); err != nil {
+			_ = c.ReplyError(ErrInvalidParams)
+			return
+		}
+		_ = c.Reply(map[string]string{"name": name})
+	})
+	startLoopback(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	resps, err := s.Request(ctx, "whois", "node-1")
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 1) {
+		assert.Equal(t, OKCode, resps[0].Body.Code)
+		assert.JSONEq(t, `{"name":"node-1"}`, string(resps[0].Body.Data))
+		assert.Equal(t, s.conn.LocalAddr().(*net.UDPAddr).Port, resps[0].Src.Port)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	resps, err = s.Request(ctx, "whois", 42)
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 1) {
+		assert.Equal(t, ErrInvalidParams.Code, resps[0].Body.Code)
+	}
+}
+
+func TestRequestReplyMarker(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.19", 19027, 1024)
+	notices := make(chan string, 1)
+	s.OnCmd("notice", func(c *MessageContext) {
+		notices <- c.Body.Msg
+	})
+	var asked int32
+	s.OnCmd("ask", func(c *MessageContext) {
+		atomic.AddInt32(&asked, 1)
+		_ = c.ReplyError(NewError(1, ""))
+	})
+	startLoopback(t, s)
+
+	// a message carrying msg is not a reply
+	err := s.SendJson(&RespBody{Cmd: "notice", Msg: "hello"})
+	assert.NoError(t, err)
+	select {
+	case msg := <-notices:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(time.Second):
+		t.Fatal("message not routed")
+	}
+
+	// a reply without msg is not routed back to the handlers
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	resps, err := s.Request(ctx, "ask", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 1) {
+		assert.Equal(t, 1, resps[0].Body.Code)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&asked))
+}
@@ -0,0 +1,183 @@
+package multicast
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xuzq3/glib/crypto"
+)
+
+// A secured packet is laid out as
+//
+//	version | flags | timestamp ms (8 bytes) | nonce (16 bytes) | payload | hmac-sha256
+//
+// The hmac covers everything before it. When encrypted, the payload is the
+// AES-GCM cipher text of the message.
+const (
+	secVersion       = 1
+	secFlagEncrypted = 1 << 0
+	secNonceSize     = 16
+	secHeaderSize    = 2 + 8 + secNonceSize
+	secMacSize       = sha256.Size
+
+	defaultMaxSkew = time.Second * 30
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated packet")
+	ErrReplayed        = errors.New("replayed packet")
+	ErrEmptyKey        = errors.New("empty security key")
+)
+
+// Security configures the authentication of the packets of a server. All
+// members of the group must share the same configuration.
+type Security struct {
+	// Key signs every packet with HMAC-SHA256.
+	Key []byte
+	// EncryptKey, when set, encrypts the payloads with AES-GCM. It must be
+	// 16, 24 or 32 bytes long.
+	EncryptKey []byte
+	// MaxSkew is the accepted difference between the timestamp of a packet
+	// and the local clock, 30 seconds by default. Nonces are remembered
+	// for twice as long to reject replays.
+	MaxSkew time.Duration
+}
+
+// SetSecurity signs, and optionally encrypts, the packets sent by the
+// server and drops the inbound packets failing verification before they
+// are routed. It must be called before Start, nil disables it.
+func (s *Server) SetSecurity(sec *Security) error {
+	if sec == nil {
+		s.security = nil
+		return nil
+	}
+	p, err := newPacketSecurity(sec)
+	if err != nil {
+		return err
+	}
+	s.security = p
+	return nil
+}
+
+type packetSecurity struct {
+	key     []byte
+	cipher  *crypto.AesGcmCipher
+	maxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[[secNonceSize]byte]time.Time
+	purged time.Time
+}
+
+func newPacketSecurity(sec *Security) (*packetSecurity, error) {
+	if len(sec.Key) == 0 {
+		return nil, ErrEmptyKey
+	}
+	p := &packetSecurity{
+		key:     sec.Key,
+		maxSkew: sec.MaxSkew,
+		nonces:  make(map[[secNonceSize]byte]time.Time),
+	}
+	if p.maxSkew <= 0 {
+		p.maxSkew = defaultMaxSkew
+	}
+	if len(sec.EncryptKey) > 0 {
+		cipher, err := crypto.NewAesGcmCipher(sec.EncryptKey)
+		if err != nil {
+			return nil, err
+		}
+		p.cipher = cipher
+	}
+	return p, nil
+}
+
+// overhead is the number of bytes seal adds to a packet.
+func (p *packetSecurity) overhead() int {
+	n := secHeaderSize + secMacSize
+	if p.cipher != nil {
+		n += p.cipher.Overhead()
+	}
+	return n
+}
+
+func (p *packetSecurity) seal(b []byte, now time.Time) ([]byte, error) {
+	var flags byte
+	payload := b
+	if p.cipher != nil {
+		var err error
+		payload, err = p.cipher.Encrypt(b)
+		if err != nil {
+			return nil, err
+		}
+		flags |= secFlagEncrypted
+	}
+
+	packet := make([]byte, secHeaderSize, secHeaderSize+len(payload)+secMacSize)
+	packet[0] = secVersion
+	packet[1] = flags
+	binary.BigEndian.PutUint64(packet[2:], uint64(now.UnixNano()/int64(time.Millisecond)))
+	if _, err := io.ReadFull(rand.Reader, packet[10:secHeaderSize]); err != nil {
+		return nil, err
+	}
+	packet = append(packet, payload...)
+	return append(packet, crypto.HmacSha256(p.key, packet)...), nil
+}
+
+// open verifies packet and returns the message it carries.
+func (p *packetSecurity) open(packet []byte, now time.Time) ([]byte, error) {
+	if len(packet) < secHeaderSize+secMacSize || packet[0] != secVersion {
+		return nil, ErrUnauthenticated
+	}
+	signed := packet[:len(packet)-secMacSize]
+	if !crypto.VerifyHmacSha256(p.key, signed, packet[len(signed):]) {
+		return nil, ErrUnauthenticated
+	}
+
+	ms := int64(binary.BigEndian.Uint64(packet[2:]))
+	sent := time.Unix(0, ms*int64(time.Millisecond))
+	if sent.Before(now.Add(-p.maxSkew)) || sent.After(now.Add(p.maxSkew)) {
+		return nil, ErrReplayed
+	}
+	var nonce [secNonceSize]byte
+	copy(nonce[:], packet[10:secHeaderSize])
+	if !p.remember(nonce, now) {
+		return nil, ErrReplayed
+	}
+
+	payload := signed[secHeaderSize:]
+	if packet[1]&secFlagEncrypted == 0 {
+		if p.cipher != nil {
+			return nil, ErrUnauthenticated
+		}
+		return payload, nil
+	}
+	if p.cipher == nil {
+		return nil, ErrUnauthenticated
+	}
+	return p.cipher.Decrypt(payload)
+}
+
+// remember records nonce and reports whether it was new. Nonces are kept
+// as long as their packets pass the timestamp check.
+func (p *packetSecurity) remember(nonce [secNonceSize]byte, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.purged) > p.maxSkew {
+		for n, expires := range p.nonces {
+			if now.After(expires) {
+				delete(p.nonces, n)
+			}
+		}
+		p.purged = now
+	}
+	if _, ok := p.nonces[nonce]; ok {
+		return false
+	}
+	p.nonces[nonce] = now.Add(p.maxSkew * 2)
+	return true
+}
@@ -0,0 +1,96 @@
+package multicast
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketSecurity(t *testing.T) {
+	now := time.Now()
+	msg := []byte(`{"cmd":"ping"}`)
+	signer, err := newPacketSecurity(&Security{Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter, err := newPacketSecurity(&Security{Key: []byte("secret"), EncryptKey: []byte("1234567890ABCDEF")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := signer.seal(msg, now)
+	assert.NoError(t, err)
+	opened, err := signer.open(packet, now)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, opened)
+	// replayed
+	_, err = signer.open(packet, now)
+	assert.Equal(t, ErrReplayed, err)
+
+	// tampered
+	packet, _ = signer.seal(msg, now)
+	packet[secHeaderSize] ^= 1
+	_, err = signer.open(packet, now)
+	assert.Equal(t, ErrUnauthenticated, err)
+
+	// too old
+	packet, _ = signer.seal(msg, now.Add(-time.Minute))
+	_, err = signer.open(packet, now)
+	assert.Equal(t, ErrReplayed, err)
+
+	// encrypted
+	packet, _ = encrypter.seal(msg, now)
+	assert.False(t, bytes.Contains(packet, msg))
+	opened, err = encrypter.open(packet, now)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, opened)
+
+	// plain packets are refused when encryption is on, and the reverse
+	packet, _ = signer.seal(msg, now)
+	_, err = encrypter.open(packet, now)
+	assert.Equal(t, ErrUnauthenticated, err)
+	packet, _ = encrypter.seal(msg, now)
+	_, err = signer.open(packet, now)
+	assert.Equal(t, ErrUnauthenticated, err)
+
+	_, err = newPacketSecurity(&Security{})
+	assert.Equal(t, ErrEmptyKey, err)
+}
+
+func TestServerSecurity(t *testing.T) {
+	s := NewServer("0.0.0.0", "239.0.0.15", 19017, 1024)
+	err := s.SetSecurity(&Security{Key: []byte("secret"), EncryptKey: []byte("1234567890ABCDEF")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 2)
+	s.OnCmd("ping", func(c *MessageContext) {
+		received <- c.Body.Seqno
+	})
+	startLoopback(t, s)
+
+	// a forged packet sent without the key is dropped
+	forger, err := net.DialUDP("udp4", nil, s.groupAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forger.Close()
+	_, err = forger.Write([]byte(`{"cmd":"ping","seqno":"forged"}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SendJson(&Body{Cmd: "ping", Seqno: "signed"}))
+	select {
+	case seqno := <-received:
+		assert.Equal(t, "signed", seqno)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+	select {
+	case seqno := <-received:
+		t.Fatalf("unexpected message %s", seqno)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
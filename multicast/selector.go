@@ -0,0 +1,251 @@
+package multicast
+
+import (
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultReloadInterval = time.Second * 30
+
+// InterfaceSelector picks the interfaces the server may join the group on.
+// The server prefers the first interfaces returned, it falls back to the
+// loopback interface when none is returned.
+type InterfaceSelector interface {
+	Select(ifaces []net.Interface, ipv6 bool) []*net.Interface
+}
+
+type InterfaceSelectorFunc func(ifaces []net.Interface, ipv6 bool) []*net.Interface
+
+func (f InterfaceSelectorFunc) Select(ifaces []net.Interface, ipv6 bool) []*net.Interface {
+	return f(ifaces, ipv6)
+}
+
+// InterfaceEvent reports a change of the interfaces of a server. In single
+// interface mode Old is the interface replaced by New. In multi interface
+// mode an event with a nil Old reports a joined interface, an event with a
+// nil New a left one.
+type InterfaceEvent struct {
+	Old *net.Interface
+	New *net.Interface
+}
+
+type InterfaceHandler func(e InterfaceEvent)
+
+// SetInterfaceSelector sets the selection of the interfaces, which is
+// DefaultInterfaceSelector when sel is nil. It must be called before Start.
+func (s *Server) SetInterfaceSelector(sel InterfaceSelector) {
+	if sel == nil {
+		sel = DefaultInterfaceSelector()
+	}
+	s.selector = sel
+}
+
+// SetReloadInterval sets the period at which the interfaces are selected
+// again, 30 seconds by default. It must be called before Start.
+func (s *Server) SetReloadInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	s.reloadInterval = interval
+}
+
+// OnInterfaceChange adds handlers called when the interfaces of the server
+// change. It must be called before Start.
+func (s *Server) OnInterfaceChange(handlers ...InterfaceHandler) {
+	s.ifaceHandlers = append(s.ifaceHandlers, handlers...)
+}
+
+func (s *Server) emitInterface(e InterfaceEvent) {
+	for _, handler := range s.ifaceHandlers {
+		handler(e)
+	}
+}
+
+type SelectorOption func(sel *interfaceSelector)
+
+// AllowInterfaces only selects the interfaces matching one of patterns. A
+// pattern is either a CIDR, matched by the addresses of an interface, or a
+// name pattern such as "eth*". The loopback interface is only selected
+// when allowed by name.
+func AllowInterfaces(patterns ...string) SelectorOption {
+	return func(sel *interfaceSelector) {
+		sel.allow = append(sel.allow, parsePatterns(patterns)...)
+	}
+}
+
+// DenyInterfaces skips the interfaces whose name matches one of patterns,
+// and the addresses in one of the CIDRs of patterns. An interface left
+// without address is skipped.
+func DenyInterfaces(patterns ...string) SelectorOption {
+	return func(sel *interfaceSelector) {
+		sel.deny = append(sel.deny, parsePatterns(patterns)...)
+	}
+}
+
+// PreferInterfaces orders the interfaces matching patterns first, in the
+// order of patterns. The other interfaces keep the order of the system.
+func PreferInterfaces(patterns ...string) SelectorOption {
+	return func(sel *interfaceSelector) {
+		sel.prefer = append(sel.prefer, parsePatterns(patterns)...)
+	}
+}
+
+// NewInterfaceSelector returns a selector of the interfaces that are up,
+// multicast capable and have an address of the family of the group,
+// filtered and ordered by opts.
+func NewInterfaceSelector(opts ...SelectorOption) InterfaceSelector {
+	sel := &interfaceSelector{}
+	for _, opt := range opts {
+		opt(sel)
+	}
+	return sel
+}
+
+// DefaultInterfaceSelector skips the VMware virtual interfaces and the
+// IPv4 link-local addresses.
+func DefaultInterfaceSelector() InterfaceSelector {
+	return NewInterfaceSelector(DenyInterfaces("*vmnet*", "169.254.0.0/16"))
+}
+
+// ifacePattern matches an interface by name or by address.
+type ifacePattern struct {
+	name  string
+	ipnet *net.IPNet
+}
+
+func parsePatterns(patterns []string) []ifacePattern {
+	list := make([]ifacePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+			list = append(list, ifacePattern{ipnet: ipnet})
+			continue
+		}
+		list = append(list, ifacePattern{name: strings.ToLower(pattern)})
+	}
+	return list
+}
+
+func (p *ifacePattern) matchName(name string) bool {
+	if p.ipnet != nil {
+		return false
+	}
+	ok, _ := path.Match(p.name, strings.ToLower(name))
+	return ok
+}
+
+func (p *ifacePattern) match(name string, ips []net.IP) bool {
+	if p.ipnet == nil {
+		return p.matchName(name)
+	}
+	for _, ip := range ips {
+		if p.ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type interfaceSelector struct {
+	allow  []ifacePattern
+	deny   []ifacePattern
+	prefer []ifacePattern
+}
+
+func (sel *interfaceSelector) Select(ifaces []net.Interface, ipv6 bool) []*net.Interface {
+	list := make([]*net.Interface, 0, len(ifaces))
+	ranks := make(map[string]int, len(ifaces))
+	for i := range ifaces {
+		iface := &ifaces[i]
+		ips, ok := sel.usable(iface, interfaceIPs(iface, ipv6))
+		if !ok {
+			continue
+		}
+		ranks[iface.Name] = sel.rank(iface.Name, ips)
+		list = append(list, iface)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return ranks[list[i].Name] < ranks[list[j].Name]
+	})
+	return list
+}
+
+// usable reports whether iface can be selected, along with its addresses
+// left by the deny list.
+func (sel *interfaceSelector) usable(iface *net.Interface, ips []net.IP) ([]net.IP, bool) {
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, false
+	}
+	loopback := iface.Flags&net.FlagLoopback != 0
+	if !loopback && iface.Flags&net.FlagMulticast == 0 {
+		return nil, false
+	}
+	for i := range sel.deny {
+		if sel.deny[i].matchName(iface.Name) {
+			return nil, false
+		}
+	}
+
+	kept := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		denied := false
+		for i := range sel.deny {
+			if sel.deny[i].ipnet != nil && sel.deny[i].ipnet.Contains(ip) {
+				denied = true
+				break
+			}
+		}
+		if !denied {
+			kept = append(kept, ip)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, false
+	}
+
+	if loopback || len(sel.allow) > 0 {
+		allowed := false
+		for i := range sel.allow {
+			if loopback && sel.allow[i].matchName(iface.Name) || !loopback && sel.allow[i].match(iface.Name, kept) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, false
+		}
+	}
+	return kept, true
+}
+
+// rank is the index of the first preference matched, the number of
+// preferences when none is.
+func (sel *interfaceSelector) rank(name string, ips []net.IP) int {
+	for i := range sel.prefer {
+		if sel.prefer[i].match(name, ips) {
+			return i
+		}
+	}
+	return len(sel.prefer)
+}
+
+// interfaceIPs returns the IPv4 or IPv6 addresses of iface.
+func interfaceIPs(iface *net.Interface, ipv6 bool) []net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if (ipnet.IP.To4() == nil) == ipv6 {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
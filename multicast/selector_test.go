@@ -0,0 +1,114 @@
+package multicast
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterfaceSelectorUsable(t *testing.T) {
+	up := net.FlagUp | net.FlagMulticast
+	tests := []struct {
+		name  string
+		sel   InterfaceSelector
+		iface net.Interface
+		ips   []string
+		want  bool
+	}{
+		{"default", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: up}, []string{"192.168.1.2"}, true},
+		{"down", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: net.FlagMulticast}, []string{"192.168.1.2"}, false},
+		{"no multicast", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: net.FlagUp}, []string{"192.168.1.2"}, false},
+		{"no address", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: up}, nil, false},
+		{"vmnet", DefaultInterfaceSelector(), net.Interface{Name: "VMnet8", Flags: up}, []string{"192.168.1.2"}, false},
+		{"link-local", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: up}, []string{"169.254.1.2"}, false},
+		{"link-local and global", DefaultInterfaceSelector(), net.Interface{Name: "eth0", Flags: up}, []string{"169.254.1.2", "10.0.0.2"}, true},
+		{"loopback", DefaultInterfaceSelector(), net.Interface{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}, []string{"127.0.0.1"}, false},
+		{"allowed loopback", NewInterfaceSelector(AllowInterfaces("lo")), net.Interface{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}, []string{"127.0.0.1"}, true},
+		{"loopback allowed by cidr", NewInterfaceSelector(AllowInterfaces("127.0.0.0/8")), net.Interface{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}, []string{"127.0.0.1"}, false},
+		{"allowed name", NewInterfaceSelector(AllowInterfaces("eth*")), net.Interface{Name: "eth1", Flags: up}, []string{"10.0.0.2"}, true},
+		{"not allowed name", NewInterfaceSelector(AllowInterfaces("eth*")), net.Interface{Name: "wlan0", Flags: up}, []string{"10.0.0.2"}, false},
+		{"allowed cidr", NewInterfaceSelector(AllowInterfaces("10.0.0.0/8")), net.Interface{Name: "wlan0", Flags: up}, []string{"10.0.0.2"}, true},
+		{"not allowed cidr", NewInterfaceSelector(AllowInterfaces("10.0.0.0/8")), net.Interface{Name: "wlan0", Flags: up}, []string{"192.168.1.2"}, false},
+		{"denied name", NewInterfaceSelector(DenyInterfaces("docker*")), net.Interface{Name: "docker0", Flags: up}, []string{"172.17.0.1"}, false},
+		{"denied cidr", NewInterfaceSelector(DenyInterfaces("172.16.0.0/12")), net.Interface{Name: "br0", Flags: up}, []string{"172.17.0.1"}, false},
+		{"denied over allowed", NewInterfaceSelector(AllowInterfaces("eth*"), DenyInterfaces("eth1")), net.Interface{Name: "eth1", Flags: up}, []string{"10.0.0.2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips := make([]net.IP, 0, len(tt.ips))
+			for _, ip := range tt.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			_, ok := tt.sel.(*interfaceSelector).usable(&tt.iface, ips)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+}
+
+func TestInterfaceSelectorRank(t *testing.T) {
+	sel := NewInterfaceSelector(PreferInterfaces("10.0.0.0/8", "eth*")).(*interfaceSelector)
+	assert.Equal(t, 0, sel.rank("wlan0", []net.IP{net.ParseIP("10.0.0.2")}))
+	assert.Equal(t, 1, sel.rank("eth0", []net.IP{net.ParseIP("192.168.1.2")}))
+	assert.Equal(t, 2, sel.rank("wlan0", []net.IP{net.ParseIP("192.168.1.2")}))
+}
+
+func TestInterfaceChange(t *testing.T) {
+	lo, eth := loopbackAndMulticastInterface(t)
+
+	var useEth int32
+	s := NewServer("0.0.0.0", "239.0.0.14", 19014, 1024)
+	s.SetInterfaceSelector(InterfaceSelectorFunc(func(ifaces []net.Interface, ipv6 bool) []*net.Interface {
+		if atomic.LoadInt32(&useEth) == 1 {
+			return []*net.Interface{eth}
+		}
+		return []*net.Interface{lo}
+	}))
+	s.SetReloadInterval(time.Millisecond * 20)
+	events := make(chan InterfaceEvent, 4)
+	s.OnInterfaceChange(func(e InterfaceEvent) {
+		events <- e
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	next := func() InterfaceEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no interface event")
+			return InterfaceEvent{}
+		}
+	}
+	e := next()
+	assert.Nil(t, e.Old)
+	assert.Equal(t, lo.Name, e.New.Name)
+	assert.Equal(t, []string{lo.Name}, s.Interfaces())
+
+	atomic.StoreInt32(&useEth, 1)
+	e = next()
+	assert.Equal(t, lo.Name, e.Old.Name)
+	assert.Equal(t, eth.Name, e.New.Name)
+	// the group is left on the replaced interface
+	assert.Equal(t, []string{eth.Name}, s.Interfaces())
+}
+
+// loopbackAndMulticastInterface returns the loopback interface and another
+// interface selected by default.
+func loopbackAndMulticastInterface(t *testing.T) (*net.Interface, *net.Interface) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	los := NewInterfaceSelector(AllowInterfaces("lo*")).Select(ifaces, false)
+	others := DefaultInterfaceSelector().Select(ifaces, false)
+	if len(los) == 0 || len(others) == 0 {
+		t.Skip("no loopback or multicast interface")
+	}
+	return los[0], others[0]
+}
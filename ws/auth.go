@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IdentityKey is the session key under which authenticators store the
+// identity of the connection.
+const IdentityKey = "ws.identity"
+
+// Authenticator validates an upgrade request and returns the identity of
+// the connection. Returning an error rejects the request before upgrade.
+type Authenticator func(r *http.Request) (interface{}, error)
+
+// WithAuthenticator rejects upgrade requests with 401 Unauthorized unless
+// auth accepts them. The identity is stored under IdentityKey.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = auth
+	}
+}
+
+// TokenAuth returns an Authenticator reading the token from the query
+// parameter param, falling back to the "Authorization: Bearer" header.
+func TokenAuth(param string, validate func(token string) (interface{}, error)) Authenticator {
+	return func(r *http.Request) (interface{}, error) {
+		token := r.URL.Query().Get(param)
+		if token == "" {
+			auth := r.Header.Get("Authorization")
+			if strings.HasPrefix(auth, "Bearer ") {
+				token = strings.TrimPrefix(auth, "Bearer ")
+			}
+		}
+		if token == "" {
+			return nil, ErrUnauthorized
+		}
+		return validate(token)
+	}
+}
+
+// MessageAuth returns a connect handler requiring the first message of the
+// connection to be cmd, received within timeout and accepted by validate.
+// Otherwise the connection is closed. Connections already authenticated by
+// an Authenticator skip the check.
+func MessageAuth(cmd string, timeout time.Duration, validate func(c *ConnContext, body *MessageBody) (interface{}, error)) ConnHandler {
+	return func(c *ConnContext) {
+		if _, ok := c.Get(IdentityKey); ok {
+			c.Next()
+			return
+		}
+
+		conn := c.conn.conn
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			c.AbortWithError(ErrUnauthorized)
+			return
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+
+		var body MessageBody
+		err = c.conn.codec.Decode(message, &body)
+		if err != nil || body.Cmd != cmd {
+			c.AbortWithError(ErrUnauthorized)
+			return
+		}
+		identity, err := validate(c, &body)
+		if err != nil {
+			c.AbortWithError(err)
+			return
+		}
+		c.Set(IdentityKey, identity)
+
+		err = c.WriteBody(&RespBody{
+			Cmd:   body.Cmd,
+			Seqno: body.Seqno,
+			Code:  OKCode,
+			Msg:   OKMsg,
+		})
+		if err != nil {
+			c.AbortWithError(err)
+			return
+		}
+		c.Next()
+	}
+}
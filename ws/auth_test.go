@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenAuth(t *testing.T) {
+	auth := TokenAuth("token", func(token string) (interface{}, error) {
+		if token != "secret" {
+			return nil, ErrUnauthorized
+		}
+		return "user-1", nil
+	})
+	s := NewServer(WithAuthenticator(auth))
+	identity := make(chan string, 1)
+	s.OnConnect(func(c *ConnContext) {
+		identity <- c.Session().GetString(IdentityKey)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?token=wrong", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	header := http.Header{"Authorization": []string{"Bearer secret"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "user-1", <-identity)
+}
+
+func TestMessageAuth(t *testing.T) {
+	s := NewServer()
+	s.OnConnect(MessageAuth("auth", time.Second, func(c *ConnContext, body *MessageBody) (interface{}, error) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		err := c.Server.codec.Bind(body.Data, &req)
+		if err != nil || req.Token != "secret" {
+			return nil, errors.New("invalid token")
+		}
+		return "user-1", nil
+	}))
+	s.OnCmd("whoami", func(c *MessageContext) {
+		_ = c.WriteBody(&RespBody{Cmd: "whoami", Data: c.Session().GetString(IdentityKey)})
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.WriteJSON(&SendBody{Cmd: "auth", Seqno: "1", Data: map[string]string{"token": "secret"}})
+	var resp RespBody
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, OKCode, resp.Code)
+	_ = conn.WriteJSON(&SendBody{Cmd: "whoami"})
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, "user-1", resp.Data)
+
+	rejected, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	_ = rejected.WriteJSON(&SendBody{Cmd: "whoami"})
+	_, _, err = rejected.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+}
+
+func TestMessageAuthBroadcast(t *testing.T) {
+	s := NewServer()
+	accepted := make(chan struct{}, 1)
+	s.OnConnect(MessageAuth("auth", time.Second*2, func(c *ConnContext, body *MessageBody) (interface{}, error) {
+		return "user-1", nil
+	}), func(c *ConnContext) {
+		accepted <- struct{}{}
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	pending, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Close()
+
+	authed, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authed.Close()
+	_ = authed.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, authed.WriteJSON(&SendBody{Cmd: "auth", Seqno: "1"}))
+	var resp RespBody
+	assert.NoError(t, authed.ReadJSON(&resp))
+	<-accepted
+
+	assert.NoError(t, s.Broadcast(context.Background(), "news", "hello"))
+	var body SendBody
+	assert.NoError(t, authed.ReadJSON(&body))
+	assert.Equal(t, "news", body.Cmd)
+
+	// the connection still authenticating receives nothing
+	_ = pending.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, _, err = pending.ReadMessage()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "timeout")
+	}
+}
@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Broker fans out broadcasts between the Server instances sharing it, so
+// Broadcast, SendTo and SendToRoom reach connections on every instance.
+type Broker interface {
+	// Publish sends payload to every subscriber, including the publisher.
+	Publish(ctx context.Context, payload []byte) error
+	// Subscribe calls handler for every published payload until ctx is
+	// done. The server subscribes again when it returns before.
+	Subscribe(ctx context.Context, handler func(payload []byte)) error
+}
+
+// WithBroker sets the broker used to fan out broadcasts across instances.
+// Without broker broadcasts only reach the connections of this instance.
+func WithBroker(broker Broker) Option {
+	return func(s *Server) {
+		s.broker = broker
+	}
+}
+
+// WithBroadcastTimeout bounds the time a broadcast waits for a connection
+// to accept the message, 5 seconds by default. Connections timing out are
+// closed.
+func WithBroadcastTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.broadcastTimeout = timeout
+	}
+}
+
+var _ Broker = (*memoryBroker)(nil)
+
+type memoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[*func(payload []byte)]struct{}
+}
+
+// NewMemoryBroker returns a broker connecting the servers of a single
+// process, mostly useful for tests.
+func NewMemoryBroker() Broker {
+	return &memoryBroker{
+		handlers: make(map[*func(payload []byte)]struct{}),
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, payload []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for handler := range b.handlers {
+		(*handler)(payload)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(ctx context.Context, handler func(payload []byte)) error {
+	b.mu.Lock()
+	b.handlers[&handler] = struct{}{}
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.handlers, &handler)
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,50 @@
+package ws
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var _ Broker = (*redisBroker)(nil)
+
+type redisBroker struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedisBroker returns a broker publishing on the redis pub/sub channel.
+func NewRedisBroker(client redis.UniversalClient, channel string) Broker {
+	return &redisBroker{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (b *redisBroker) Publish(ctx context.Context, payload []byte) error {
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context, handler func(payload []byte)) error {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	// wait for the subscription to be confirmed
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			handler([]byte(msg.Payload))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBroker(t *testing.T, broker Broker) {
+	a := NewServer(WithBroker(broker))
+	b := NewServer(WithBroker(broker))
+
+	received := make(chan SendBody, 10)
+	a.OnConnect(func(c *ConnContext) {
+		c.BindUser(c.Request.URL.Query().Get("uid"))
+		c.Join("lobby")
+		received <- SendBody{Cmd: "joined"}
+	})
+	hs := httptest.NewServer(http.HandlerFunc(a.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http")+"?uid=u1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			var body SendBody
+			if err := conn.ReadJSON(&body); err != nil {
+				return
+			}
+			received <- body
+		}
+	}()
+	<-received
+	// let both subscriptions settle
+	time.Sleep(500 * time.Millisecond)
+
+	ctx := context.Background()
+	assert.NoError(t, b.SendTo(ctx, "u2", "skipped", nil))
+	assert.NoError(t, b.SendTo(ctx, "u1", "user", 1))
+	assert.NoError(t, b.SendToRoom(ctx, "lobby", "room", 2))
+	assert.NoError(t, b.Broadcast(ctx, "all", 3))
+
+	for _, want := range []string{"user", "room", "all"} {
+		select {
+		case body := <-received:
+			assert.Equal(t, want, body.Cmd)
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", want)
+		}
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	testBroker(t, NewMemoryBroker())
+}
+
+func TestRedisBroker(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	testBroker(t, NewRedisBroker(client, "ws:broadcast"))
+}
+
+// flakyBroker fails the first subscriptions, like redis being unavailable
+// at startup.
+type flakyBroker struct {
+	Broker
+	failures int32
+}
+
+func (b *flakyBroker) Subscribe(ctx context.Context, handler func(payload []byte)) error {
+	if atomic.AddInt32(&b.failures, -1) >= 0 {
+		return errors.New("connection refused")
+	}
+	return b.Broker.Subscribe(ctx, handler)
+}
+
+func TestBrokerResubscribe(t *testing.T) {
+	testBroker(t, &flakyBroker{Broker: NewMemoryBroker(), failures: 2})
+}
+
+func TestWriteDeadline(t *testing.T) {
+	s := NewServer()
+	closed := make(chan struct{})
+	s.OnConnect(func(c *ConnContext) {
+		err := c.conn.writeBodyDeadline(&SendBody{Cmd: "late"}, time.Now().Add(-time.Second))
+		assert.Error(t, err)
+		close(closed)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-closed
+
+	// the connection timing out is closed
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+	assert.False(t, strings.Contains(err.Error(), "timeout"))
+}
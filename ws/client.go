@@ -0,0 +1,258 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xuzq3/glib/logx"
+)
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Client dials a websocket server and routes inbound messages with the same
+// OnConnect/OnCmd/Group handlers as Server. Once started it reconnects with
+// exponential backoff whenever the connection is lost, running the connect
+// handlers again on every new connection.
+type Client struct {
+	engine
+	url        string
+	header     http.Header
+	dialer     *websocket.Dialer
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu     sync.Mutex
+	conn   *connection
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		engine:     newEngine(),
+		url:        url,
+		dialer:     websocket.DefaultDialer,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+}
+
+func (c *Client) SetHeader(header http.Header) {
+	c.header = header
+}
+
+func (c *Client) SetDialer(dialer *websocket.Dialer) {
+	c.dialer = dialer
+}
+
+// SetCodec sets the codec of the client and requests it from the server as
+// subprotocol.
+func (c *Client) SetCodec(codec Codec) {
+	c.codec = codec
+	c.codecs = map[string]Codec{codec.Name(): codec}
+}
+
+// SetDispatch sets how inbound messages are handed to handlers, see
+// WithDispatch.
+func (c *Client) SetDispatch(mode DispatchMode, workers int) {
+	c.setDispatch(mode, workers)
+}
+
+// SetStreamTimeout sets how long an incoming stream waits for its next
+// chunk, see WithStreamTimeout.
+func (c *Client) SetStreamTimeout(timeout time.Duration) {
+	c.streamTimeout = timeout
+}
+
+// SetMaxStreams sets how many incoming streams the client may have open at
+// once, see WithMaxStreams. Incoming streams belong to the client rather
+// than to its connection, so the server resumes them after a reconnect.
+func (c *Client) SetMaxStreams(n int) {
+	c.maxStreams = n
+}
+
+// SetMetrics sets the metrics receiving the events of the client.
+func (c *Client) SetMetrics(metrics Metrics) {
+	c.metrics = metrics
+}
+
+// SetBackoff sets the first and the maximum delay between reconnects.
+func (c *Client) SetBackoff(min, max time.Duration) {
+	c.minBackoff = min
+	c.maxBackoff = max
+}
+
+// Start dials the server once and returns the dial error if any. After a
+// successful dial the connection is served in the background and
+// reestablished until Close is called.
+func (c *Client) Start() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.done = make(chan struct{})
+	c.setCurrent(conn)
+	go c.loop(conn)
+	return nil
+}
+
+func (c *Client) Close() error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+
+	conn := c.current()
+	if conn != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		_ = conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		_ = conn.conn.Close()
+	}
+	<-c.done
+	c.closeStreams(c, ErrConnectionClosed)
+	c.streamWg.Wait()
+	return nil
+}
+
+func (c *Client) Connected() bool {
+	return c.current() != nil
+}
+
+func (c *Client) WriteMessage(data []byte) error {
+	conn := c.current()
+	if conn == nil {
+		return ErrConnectionClosed
+	}
+	return conn.writeMessage(websocket.TextMessage, data)
+}
+
+func (c *Client) WriteJson(v interface{}) error {
+	conn := c.current()
+	if conn == nil {
+		return ErrConnectionClosed
+	}
+	return conn.writeJson(v)
+}
+
+// WriteBody encodes v, typically a SendBody or RespBody, with the codec of
+// the client.
+func (c *Client) WriteBody(v interface{}) error {
+	conn := c.current()
+	if conn == nil {
+		return ErrConnectionClosed
+	}
+	return conn.writeBody(v)
+}
+
+func (c *Client) Send(cmd string, data interface{}) error {
+	return c.WriteBody(&SendBody{
+		Cmd:   cmd,
+		Seqno: NewSeqno(),
+		Data:  data,
+	})
+}
+
+// Call sends cmd to the server and blocks until the response with the same
+// seqno arrives, ctx expires or the connection is lost.
+func (c *Client) Call(ctx context.Context, cmd string, data interface{}) (*RespBody, error) {
+	conn := c.current()
+	if conn == nil {
+		return nil, ErrConnectionClosed
+	}
+	return conn.call(ctx, cmd, data)
+}
+
+// OpenStream starts a chunked transfer of size bytes (-1 if unknown) to
+// the handlers of cmd on the server. When the server authenticated the
+// client, opening a stream with the same id after a reconnect resumes it
+// from the writer's Offset.
+func (c *Client) OpenStream(ctx context.Context, cmd string, id string, size int64, meta interface{}) (*StreamWriter, error) {
+	conn := c.current()
+	if conn == nil {
+		return nil, ErrConnectionClosed
+	}
+	return conn.openStream(ctx, cmd, id, size, meta)
+}
+
+func (c *Client) current() *connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// setCurrent publishes conn unless the client has been closed meanwhile.
+func (c *Client) setCurrent(conn *connection) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn != nil && c.ctx.Err() != nil {
+		return false
+	}
+	c.conn = conn
+	return true
+}
+
+func (c *Client) dial() (*connection, error) {
+	dialer := *c.dialer
+	if len(c.codecs) > 0 {
+		dialer.Subprotocols = []string{c.codec.Name()}
+	}
+	conn, _, err := dialer.Dial(c.url, c.header)
+	if err != nil {
+		return nil, err
+	}
+	wc := newConnection(conn, c.codecFor(conn.Subprotocol()), c.metrics)
+	wc.client = c
+	return wc, nil
+}
+
+func (c *Client) loop(conn *connection) {
+	defer close(c.done)
+
+	for {
+		if !c.setCurrent(conn) {
+			_ = conn.conn.Close()
+			return
+		}
+		c.serve(conn)
+		c.setCurrent(nil)
+		_ = conn.conn.Close()
+
+		conn = c.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials until it succeeds or the client is closed, doubling the
+// delay after every failure.
+func (c *Client) reconnect() *connection {
+	backoff := c.minBackoff
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := c.dial()
+		if err == nil {
+			return conn
+		}
+		logx.WithError(err).Error("websocket reconnect failed")
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientReconnect(t *testing.T) {
+	s := NewServer()
+	serverConns := make(chan *websocket.Conn, 4)
+	s.OnConnect(func(c *ConnContext) {
+		serverConns <- c.Conn
+	})
+	hanging := make(chan struct{}, 1)
+	s.OnCmd("hang", func(c *MessageContext) {
+		hanging <- struct{}{}
+	})
+	s.OnCmd("echo", func(c *MessageContext) {
+		_ = c.Reply(c.JsonBody.Data)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	cl.SetBackoff(time.Millisecond*10, time.Millisecond*50)
+	var connects int32
+	cl.OnConnect(func(c *ConnContext) {
+		atomic.AddInt32(&connects, 1)
+	})
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	first := <-serverConns
+
+	// a call pending when the connection drops fails
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cl.Call(context.Background(), "hang", nil)
+		errs <- err
+	}()
+	<-hanging
+	_ = first.Close()
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrConnectionClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Call not failed by the disconnect")
+	}
+
+	// the client reconnects and runs the connect handlers again
+	select {
+	case <-serverConns:
+	case <-time.After(time.Second):
+		t.Fatal("client not reconnected")
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&connects) == 2 && cl.Connected()
+	}, time.Second, time.Millisecond*10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := cl.Call(ctx, "echo", "hi")
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, OKCode, resp.Code)
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	s := NewServer()
+	serverConns := make(chan *websocket.Conn, 1)
+	s.OnConnect(func(c *ConnContext) {
+		serverConns <- c.Conn
+	})
+	var mu sync.Mutex
+	var attempts []time.Time
+	accept := int32(1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&accept) == 0 {
+			mu.Lock()
+			attempts = append(attempts, time.Now())
+			mu.Unlock()
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.Upgrade(w, r)
+	}))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	cl.SetBackoff(time.Millisecond*20, time.Second)
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	atomic.StoreInt32(&accept, 0)
+	_ = (<-serverConns).Close()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) >= 4
+	}, time.Second*2, time.Millisecond*10)
+	atomic.StoreInt32(&accept, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the delay doubles after every failure: 20ms, 40ms, 80ms
+	for i := 2; i < 4; i++ {
+		prev := attempts[i-1].Sub(attempts[i-2])
+		gap := attempts[i].Sub(attempts[i-1])
+		assert.Greater(t, int64(gap), int64(prev*3/2))
+	}
+}
@@ -0,0 +1,183 @@
+package ws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+)
+
+// Codec converts between websocket frames and message envelopes. The codec
+// of a connection is chosen by the Sec-WebSocket-Protocol subprotocol
+// matching its Name, falling back to the default codec of the server.
+type Codec interface {
+	// Name is the subprotocol announced for this codec.
+	Name() string
+	// MessageType is the frame type used for encoded messages.
+	MessageType() int
+	// Decode parses the envelope of an inbound message. The payload is kept
+	// undecoded in MessageBody.Data.
+	Decode(message []byte, body *MessageBody) error
+	// Bind decodes a payload taken from MessageBody.Data into v.
+	Bind(data []byte, v interface{}) error
+	// Encode serializes an outbound envelope such as SendBody or RespBody.
+	Encode(v interface{}) ([]byte, error)
+}
+
+var (
+	errInvalidEnvelope = errors.New("invalid binary envelope")
+)
+
+var _ Codec = (*jsonCodec)(nil)
+
+type jsonCodec struct{}
+
+// NewJsonCodec returns the default codec, exchanging json text frames.
+func NewJsonCodec() Codec {
+	return jsonCodec{}
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Decode(message []byte, body *MessageBody) error {
+	return json.Unmarshal(message, body)
+}
+
+func (jsonCodec) Bind(data []byte, v interface{}) error {
+	return binding.JSON.BindBody(data, v)
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+var _ Codec = (*binaryCodec)(nil)
+
+type binaryCodec struct{}
+
+// NewBinaryCodec returns a codec exchanging binary frames with a compact
+// envelope:
+//
+//	uvarint len | cmd | uvarint len | seqno | varint code | uvarint len | msg | data
+//
+// The data runs to the end of the frame. []byte and json.RawMessage
+// payloads are sent as is, other values are encoded as json.
+func NewBinaryCodec() Codec {
+	return binaryCodec{}
+}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (binaryCodec) Decode(message []byte, body *MessageBody) error {
+	var err error
+	b := message
+	if body.Cmd, b, err = readString(b); err != nil {
+		return err
+	}
+	if body.Seqno, b, err = readString(b); err != nil {
+		return err
+	}
+	code, n := binary.Varint(b)
+	if n <= 0 {
+		return errInvalidEnvelope
+	}
+	body.Code = int(code)
+	b = b[n:]
+	if body.Msg, b, err = readString(b); err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		body.Data = b
+	}
+	return nil
+}
+
+func (binaryCodec) Bind(data []byte, v interface{}) error {
+	if p, ok := v.(*[]byte); ok {
+		*p = append((*p)[:0], data...)
+		return nil
+	}
+	return binding.JSON.BindBody(data, v)
+}
+
+func (binaryCodec) Encode(v interface{}) ([]byte, error) {
+	var (
+		cmd, seqno, msg string
+		code            int
+		data            interface{}
+	)
+	switch body := v.(type) {
+	case *SendBody:
+		cmd, seqno, data = body.Cmd, body.Seqno, body.Data
+	case SendBody:
+		cmd, seqno, data = body.Cmd, body.Seqno, body.Data
+	case *RespBody:
+		cmd, seqno, code, msg, data = body.Cmd, body.Seqno, body.Code, body.Msg, body.Data
+	case RespBody:
+		cmd, seqno, code, msg, data = body.Cmd, body.Seqno, body.Code, body.Msg, body.Data
+	case *MessageBody:
+		cmd, seqno, code, msg, data = body.Cmd, body.Seqno, body.Code, body.Msg, []byte(body.Data)
+	default:
+		return nil, fmt.Errorf("binary codec can't encode %T", v)
+	}
+
+	var payload []byte
+	switch d := data.(type) {
+	case nil:
+	case []byte:
+		payload = d
+	case json.RawMessage:
+		payload = d
+	default:
+		js, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		payload = js
+	}
+
+	b := make([]byte, 0, len(cmd)+len(seqno)+len(msg)+len(payload)+4*binary.MaxVarintLen64)
+	b = appendString(b, cmd)
+	b = appendString(b, seqno)
+	b = appendVarint(b, int64(code))
+	b = appendString(b, msg)
+	b = append(b, payload...)
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(s)))
+	b = append(b, buf[:n]...)
+	return append(b, s...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return "", nil, errInvalidEnvelope
+	}
+	b = b[n:]
+	return string(b[:l]), b[l:], nil
+}
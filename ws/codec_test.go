@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryCodec(t *testing.T) {
+	codec := NewBinaryCodec()
+	assert.Equal(t, websocket.BinaryMessage, codec.MessageType())
+
+	b, err := codec.Encode(&RespBody{
+		Cmd:   "device.status",
+		Seqno: "1",
+		Code:  -3,
+		Msg:   "failed",
+		Data:  map[string]int{"a": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body MessageBody
+	err = codec.Decode(b, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "device.status", body.Cmd)
+	assert.Equal(t, "1", body.Seqno)
+	assert.Equal(t, -3, body.Code)
+	assert.Equal(t, "failed", body.Msg)
+
+	var data struct {
+		A int `json:"a"`
+	}
+	err = codec.Bind(body.Data, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, data.A)
+}
+
+func TestBinaryCodecRawPayload(t *testing.T) {
+	codec := NewBinaryCodec()
+	payload := []byte{0x00, 0xff, 0x10}
+
+	b, err := codec.Encode(&SendBody{Cmd: "upload", Data: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body MessageBody
+	err = codec.Decode(b, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []byte
+	err = codec.Bind(body.Data, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, payload, raw)
+}
+
+func TestBinaryCodecInvalid(t *testing.T) {
+	codec := NewBinaryCodec()
+	var body MessageBody
+	err := codec.Decode([]byte{0x10, 'a'}, &body)
+	assert.Error(t, err)
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	s := NewServer()
+	s.AddCodec(NewBinaryCodec())
+	s.OnCmd("echo", func(c *MessageContext) {
+		var data struct {
+			A int `json:"a"`
+		}
+		if err := c.ShouldBind(&data); err != nil {
+			_ = c.ReplyError(err)
+			return
+		}
+		_ = c.Reply(map[string]interface{}{
+			"a":           data.A,
+			"subprotocol": c.Conn.Subprotocol(),
+		})
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	call := func(cl *Client) (int, string) {
+		if err := cl.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cl.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := cl.Call(ctx, "echo", map[string]int{"a": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, OKCode, resp.Code)
+		var data struct {
+			A           int    `json:"a"`
+			Subprotocol string `json:"subprotocol"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Data.(json.RawMessage), &data))
+		return data.A, data.Subprotocol
+	}
+
+	// the client selects binary through the subprotocol
+	binaryClient := NewClient(url)
+	binaryClient.SetCodec(NewBinaryCodec())
+	a, subprotocol := call(binaryClient)
+	assert.Equal(t, 1, a)
+	assert.Equal(t, "binary", subprotocol)
+
+	// without subprotocol the server falls back to json
+	a, subprotocol = call(NewClient(url))
+	assert.Equal(t, 1, a)
+	assert.Equal(t, "", subprotocol)
+
+	dial := func(subprotocols ...string) (*websocket.Conn, int) {
+		dialer := websocket.Dialer{Subprotocols: subprotocols}
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		codec := s.codecFor(conn.Subprotocol())
+		b, err := codec.Encode(&SendBody{Cmd: "echo", Seqno: "1", Data: map[string]int{"a": 2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, conn.WriteMessage(codec.MessageType(), b))
+		messageType, _, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		return conn, messageType
+	}
+
+	// replies are binary frames once binary is negotiated
+	conn, messageType := dial("binary")
+	assert.Equal(t, "binary", conn.Subprotocol())
+	assert.Equal(t, websocket.BinaryMessage, messageType)
+	_ = conn.Close()
+
+	// an unknown subprotocol falls back to json as well
+	conn, messageType = dial("msgpack")
+	assert.Equal(t, "", conn.Subprotocol())
+	assert.Equal(t, websocket.TextMessage, messageType)
+	_ = conn.Close()
+}
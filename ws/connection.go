@@ -0,0 +1,228 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xuzq3/glib/internal/ratelimit"
+)
+
+// connection holds the state shared by the ConnContext and every
+// MessageContext of a single websocket connection.
+type connection struct {
+	conn    *websocket.Conn
+	server  *Server
+	client  *Client
+	codec   Codec
+	metrics Metrics
+	session *Session
+	request *http.Request
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[string]chan *RespBody
+	closed  bool
+	limits  map[*rateLimit]*ratelimit.Limiter
+	user    string
+	rooms   map[string]struct{}
+}
+
+func newConnection(conn *websocket.Conn, codec Codec, metrics Metrics) *connection {
+	return &connection{
+		conn:    conn,
+		codec:   codec,
+		metrics: metrics,
+		session: newSession(),
+		pending: make(map[string]chan *RespBody),
+	}
+}
+
+func (c *connection) writeMessage(messageType int, data []byte) error {
+	return c.writeMessageDeadline(messageType, data, time.Time{})
+}
+
+// writeMessageDeadline fails when data isn't written before deadline, zero
+// meaning no deadline. The connection is closed on timeout, as it can't be
+// written anymore.
+func (c *connection) writeMessageDeadline(messageType int, data []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if deadline.IsZero() {
+		return c.conn.WriteMessage(messageType, data)
+	}
+
+	_ = c.conn.SetWriteDeadline(deadline)
+	err := c.conn.WriteMessage(messageType, data)
+	_ = c.conn.SetWriteDeadline(time.Time{})
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		_ = c.conn.Close()
+	}
+	return err
+}
+
+func (c *connection) writeJson(v interface{}) error {
+	return c.writeJsonAs(bodyCmd(v), v)
+}
+
+// writeJsonAs writes v as json, reporting it to the metrics as cmd.
+func (c *connection) writeJsonAs(cmd string, v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = c.writeMessage(websocket.TextMessage, js)
+	if err == nil {
+		c.metrics.MessageSent(cmd)
+	}
+	return err
+}
+
+func (c *connection) writeBody(v interface{}) error {
+	return c.writeBodyAs(bodyCmd(v), v, time.Time{})
+}
+
+func (c *connection) writeBodyDeadline(v interface{}, deadline time.Time) error {
+	return c.writeBodyAs(bodyCmd(v), v, deadline)
+}
+
+// writeBodyAs encodes v with the codec of the connection, reporting it to
+// the metrics as cmd.
+func (c *connection) writeBodyAs(cmd string, v interface{}, deadline time.Time) error {
+	b, err := c.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	err = c.writeMessageDeadline(c.codec.MessageType(), b, deadline)
+	if err == nil {
+		c.metrics.MessageSent(cmd)
+	}
+	return err
+}
+
+// call sends cmd with a new seqno and waits for the response carrying the
+// same seqno. It must not run on the goroutine reading the connection,
+// otherwise the response can never be read.
+func (c *connection) call(ctx context.Context, cmd string, data interface{}) (*RespBody, error) {
+	seqno := NewSeqno()
+	ch := make(chan *RespBody, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrConnectionClosed
+	}
+	c.pending[seqno] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seqno)
+		c.mu.Unlock()
+	}()
+
+	err := c.writeBody(&SendBody{
+		Cmd:   cmd,
+		Seqno: seqno,
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrConnectionClosed
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve delivers message to the pending call waiting for its seqno and
+// reports whether such a call existed.
+func (c *connection) resolve(body *MessageBody) bool {
+	if body.Seqno == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[body.Seqno]
+	if ok {
+		delete(c.pending, body.Seqno)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	ch <- &RespBody{
+		Cmd:   body.Cmd,
+		Seqno: body.Seqno,
+		Code:  body.Code,
+		Msg:   body.Msg,
+		Data:  body.Data,
+	}
+	return true
+}
+
+// limiter returns the rate limiter of the connection for the RateLimit
+// middleware configured by limit.
+func (c *connection) limiter(limit *rateLimit) *ratelimit.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limits == nil {
+		c.limits = make(map[*rateLimit]*ratelimit.Limiter)
+	}
+	l, ok := c.limits[limit]
+	if !ok {
+		l = ratelimit.New(limit.rate, limit.burst, 0)
+		c.limits[limit] = l
+	}
+	return l
+}
+
+// accept makes the connection reachable by Server.Broadcast, once the
+// connect handlers let it through.
+func (c *connection) accept() {
+	if c.server != nil {
+		c.server.hub.add(c)
+	}
+}
+
+func (c *connection) bindUser(userID string) {
+	if c.server != nil {
+		c.server.hub.bindUser(c, userID)
+	}
+}
+
+func (c *connection) join(room string) {
+	if c.server != nil {
+		c.server.hub.join(c, room)
+	}
+}
+
+func (c *connection) leave(room string) {
+	if c.server != nil {
+		c.server.hub.leave(c, room)
+	}
+}
+
+// close fails every pending call with ErrConnectionClosed.
+func (c *connection) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for seqno, ch := range c.pending {
+		close(ch)
+		delete(c.pending, seqno)
+	}
+}
@@ -2,6 +2,8 @@ package ws
 
 import (
 	"context"
+	"net/http"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -9,8 +11,11 @@ type ConnHandler func(c *ConnContext)
 
 type ConnContext struct {
 	Conn     *websocket.Conn
+	Request  *http.Request
 	Server   *Server
+	Client   *Client
 	Error    error
+	conn     *connection
 	handlers []ConnHandler
 	index    int8
 	ctx      context.Context
@@ -18,8 +23,11 @@ type ConnContext struct {
 
 func (c *ConnContext) reset() {
 	c.Conn = nil
+	c.Request = nil
 	c.Server = nil
+	c.Client = nil
 	c.Error = nil
+	c.conn = nil
 	c.handlers = nil
 	c.index = -1
 	c.ctx = nil
@@ -57,3 +65,69 @@ func (c *ConnContext) WithContext(ctx context.Context) *ConnContext {
 	c.ctx = ctx
 	return c
 }
+
+func (c *ConnContext) WriteMessage(data []byte) error {
+	return c.conn.writeMessage(websocket.TextMessage, data)
+}
+
+func (c *ConnContext) WriteJson(v interface{}) error {
+	return c.conn.writeJson(v)
+}
+
+// WriteBody encodes v, typically a SendBody or RespBody, with the codec of
+// the connection.
+func (c *ConnContext) WriteBody(v interface{}) error {
+	return c.conn.writeBody(v)
+}
+
+// Call sends cmd to the client and blocks until the response with the same
+// seqno arrives, ctx expires or the connection is closed. The returned
+// RespBody.Data holds the raw json.RawMessage payload.
+//
+// Connect handlers run before the read loop starts, so Call must be issued
+// from a separate goroutine, which must use the Peer of the context: the
+// ConnContext is reused for another connection once the handlers return.
+func (c *ConnContext) Call(ctx context.Context, cmd string, data interface{}) (*RespBody, error) {
+	return c.conn.call(ctx, cmd, data)
+}
+
+// Peer returns a handle on the connection that goroutines can keep after
+// the connect handlers return.
+func (c *ConnContext) Peer() *Peer {
+	return &Peer{conn: c.conn}
+}
+
+func (c *ConnContext) Session() *Session {
+	return c.conn.session
+}
+
+func (c *ConnContext) Set(key string, value interface{}) {
+	c.conn.session.Set(key, value)
+}
+
+func (c *ConnContext) Get(key string) (interface{}, bool) {
+	return c.conn.session.Get(key)
+}
+
+// BindUser registers the connection as belonging to userID, so that
+// Server.SendTo reaches it.
+func (c *ConnContext) BindUser(userID string) {
+	c.conn.bindUser(userID)
+}
+
+// Join adds the connection to room, see Server.SendToRoom.
+func (c *ConnContext) Join(room string) {
+	c.conn.join(room)
+}
+
+func (c *ConnContext) Leave(room string) {
+	c.conn.leave(room)
+}
+
+// OpenStream starts a chunked transfer of size bytes (-1 if unknown) to
+// the handlers of cmd on the peer. When the peer already received a part
+// of the stream id, the writer's Offset tells where to resume. Like Call,
+// it must not be used on the read loop.
+func (c *ConnContext) OpenStream(ctx context.Context, cmd string, id string, size int64, meta interface{}) (*StreamWriter, error) {
+	return c.conn.openStream(ctx, cmd, id, size, meta)
+}
@@ -6,13 +6,19 @@ import (
 	"github.com/gin-gonic/gin/binding"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"time"
 )
 
 type MessageHandler func(ctx *MessageContext)
 
+// MessageBody is the envelope of an inbound message. Code and Msg are only
+// set when the message is a response. Data holds the undecoded payload,
+// which is json unless the connection negotiated another Codec.
 type MessageBody struct {
 	Cmd   string          `json:"cmd"`
 	Seqno string          `json:"seqno,omitempty"`
+	Code  int             `json:"code,omitempty"`
+	Msg   string          `json:"msg,omitempty"`
 	Data  json.RawMessage `json:"data,omitempty"`
 }
 
@@ -36,7 +42,12 @@ type MessageContext struct {
 	Message     []byte
 	JsonBody    *MessageBody
 	Server      *Server
+	Client      *Client
 	Error       error
+	conn        *connection
+	params      interface{}
+	stream      *Stream
+	route       string
 	handlers    []MessageHandler
 	index       int8
 	ctx         context.Context
@@ -48,7 +59,12 @@ func (c *MessageContext) reset() {
 	c.Message = nil
 	c.JsonBody = nil
 	c.Server = nil
+	c.Client = nil
 	c.Error = nil
+	c.conn = nil
+	c.params = nil
+	c.stream = nil
+	c.route = ""
 	c.handlers = nil
 	c.index = -1
 	c.ctx = nil
@@ -96,6 +112,118 @@ func (c *MessageContext) ShouldBindJson(v interface{}) error {
 	return nil
 }
 
+// ShouldBind decodes the payload into v with the codec of the connection.
+func (c *MessageContext) ShouldBind(v interface{}) error {
+	err := c.conn.codec.Bind(c.JsonBody.Data, v)
+	if err != nil {
+		err = errors.Wrap(err, "invalid params")
+		return err
+	}
+	return nil
+}
+
 func (c *MessageContext) WriteMessage(data []byte) error {
-	return c.Conn.WriteMessage(websocket.TextMessage, data)
+	return c.conn.writeMessage(websocket.TextMessage, data)
+}
+
+// WriteJson writes v as json. Like every write of the handlers, it is
+// reported to Metrics under the route of the message.
+func (c *MessageContext) WriteJson(v interface{}) error {
+	return c.conn.writeJsonAs(c.route, v)
+}
+
+// WriteBody encodes v, typically a SendBody or RespBody, with the codec of
+// the connection.
+func (c *MessageContext) WriteBody(v interface{}) error {
+	return c.conn.writeBodyAs(c.route, v, time.Time{})
+}
+
+// Reply answers the message with a successful RespBody carrying data.
+func (c *MessageContext) Reply(data interface{}) error {
+	return c.WriteBody(&RespBody{
+		Cmd:   c.JsonBody.Cmd,
+		Seqno: c.JsonBody.Seqno,
+		Code:  OKCode,
+		Msg:   OKMsg,
+		Data:  data,
+	})
+}
+
+// ReplyError answers the message with the code and msg of err, falling
+// back to ErrServerError when err is not an *Error.
+func (c *MessageContext) ReplyError(err error) error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = ErrServerError
+	}
+	return c.WriteBody(&RespBody{
+		Cmd:   c.JsonBody.Cmd,
+		Seqno: c.JsonBody.Seqno,
+		Code:  e.Code,
+		Msg:   e.Msg,
+	})
+}
+
+// Params returns the payload bound by the Validate middleware.
+func (c *MessageContext) Params() interface{} {
+	return c.params
+}
+
+// Call sends cmd to the peer and blocks until the response with the same
+// seqno arrives, ctx expires or the connection is closed. The returned
+// RespBody.Data holds the raw json.RawMessage payload.
+//
+// With DispatchSerial messages are handled on the read loop, so Call must
+// be issued from a separate goroutine, which must use the Peer of the
+// context: the MessageContext is reused once the handlers return.
+func (c *MessageContext) Call(ctx context.Context, cmd string, data interface{}) (*RespBody, error) {
+	return c.conn.call(ctx, cmd, data)
+}
+
+// Peer returns a handle on the connection that goroutines can keep after
+// the handlers return.
+func (c *MessageContext) Peer() *Peer {
+	return &Peer{conn: c.conn}
+}
+
+func (c *MessageContext) Session() *Session {
+	return c.conn.session
+}
+
+func (c *MessageContext) Set(key string, value interface{}) {
+	c.conn.session.Set(key, value)
+}
+
+func (c *MessageContext) Get(key string) (interface{}, bool) {
+	return c.conn.session.Get(key)
+}
+
+// BindUser registers the connection as belonging to userID, so that
+// Server.SendTo reaches it.
+func (c *MessageContext) BindUser(userID string) {
+	c.conn.bindUser(userID)
+}
+
+// Join adds the connection to room, see Server.SendToRoom.
+func (c *MessageContext) Join(room string) {
+	c.conn.join(room)
+}
+
+func (c *MessageContext) Leave(room string) {
+	c.conn.leave(room)
+}
+
+// Stream returns the payload of a chunked transfer targeting the cmd of
+// the handler, or nil for regular messages. Replies of the handler go to
+// the connection which started the stream.
+func (c *MessageContext) Stream() *Stream {
+	return c.stream
+}
+
+// OpenStream starts a chunked transfer of size bytes (-1 if unknown) to
+// the handlers of cmd on the peer. When the peer already received a part
+// of the stream id, the writer's Offset tells where to resume. Like Call,
+// it must not be used on the read loop.
+func (c *MessageContext) OpenStream(ctx context.Context, cmd string, id string, size int64, meta interface{}) (*StreamWriter, error) {
+	return c.conn.openStream(ctx, cmd, id, size, meta)
 }
@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DispatchMode controls how the messages of a connection are handed to
+// their handlers.
+type DispatchMode int
+
+const (
+	// DispatchSerial handles messages one by one on the read loop.
+	DispatchSerial DispatchMode = iota
+	// DispatchConcurrent handles messages on a per-connection pool of
+	// workers, without ordering guarantees.
+	DispatchConcurrent
+	// DispatchOrderedByCmd handles messages on a per-connection pool of
+	// workers, keeping messages with the same cmd in order.
+	DispatchOrderedByCmd
+)
+
+// WithDispatch sets the dispatch mode and the number of workers per
+// connection. When all workers are busy and their queues are full, the read
+// loop blocks until a message is handled.
+func WithDispatch(mode DispatchMode, workers int) Option {
+	return func(s *Server) {
+		s.setDispatch(mode, workers)
+	}
+}
+
+func (e *engine) setDispatch(mode DispatchMode, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	e.dispatchMode = mode
+	e.workers = workers
+}
+
+type dispatcher struct {
+	engine *engine
+	mode   DispatchMode
+	queues []chan *MessageContext
+	wg     sync.WaitGroup
+}
+
+func (e *engine) newDispatcher() *dispatcher {
+	d := &dispatcher{
+		engine: e,
+		mode:   e.dispatchMode,
+	}
+
+	switch d.mode {
+	case DispatchConcurrent:
+		queue := make(chan *MessageContext, e.workers)
+		d.queues = []chan *MessageContext{queue}
+		for i := 0; i < e.workers; i++ {
+			d.wg.Add(1)
+			go d.work(queue)
+		}
+	case DispatchOrderedByCmd:
+		d.queues = make([]chan *MessageContext, e.workers)
+		for i := range d.queues {
+			d.queues[i] = make(chan *MessageContext, e.workers)
+			d.wg.Add(1)
+			go d.work(d.queues[i])
+		}
+	}
+	return d
+}
+
+func (d *dispatcher) dispatch(ctx *MessageContext) {
+	switch d.mode {
+	case DispatchConcurrent:
+		d.engine.metrics.Queued(1)
+		d.queues[0] <- ctx
+	case DispatchOrderedByCmd:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(ctx.JsonBody.Cmd))
+		d.engine.metrics.Queued(1)
+		d.queues[h.Sum32()%uint32(len(d.queues))] <- ctx
+	default:
+		d.engine.run(ctx)
+	}
+}
+
+func (d *dispatcher) work(queue chan *MessageContext) {
+	defer d.wg.Done()
+	for ctx := range queue {
+		d.engine.metrics.Queued(-1)
+		d.engine.run(ctx)
+	}
+}
+
+// stop waits for the queued messages to be handled.
+func (d *dispatcher) stop() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchConcurrent(t *testing.T) {
+	s := NewServer(WithDispatch(DispatchConcurrent, 2))
+	release := make(chan struct{})
+	s.OnCmd("slow", func(c *MessageContext) {
+		<-release
+		_ = c.WriteBody(&RespBody{Cmd: "slow"})
+	})
+	s.OnCmd("fast", func(c *MessageContext) {
+		_ = c.WriteBody(&RespBody{Cmd: "fast"})
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	_ = conn.WriteJSON(&SendBody{Cmd: "slow"})
+	_ = conn.WriteJSON(&SendBody{Cmd: "fast"})
+
+	var resp RespBody
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, "fast", resp.Cmd)
+
+	close(release)
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, "slow", resp.Cmd)
+}
+
+func TestDispatchOrderedByCmd(t *testing.T) {
+	s := NewServer(WithDispatch(DispatchOrderedByCmd, 4))
+	seen := make(chan int, 100)
+	s.OnCmd("seq", func(c *MessageContext) {
+		var n int
+		_ = c.ShouldBind(&n)
+		seen <- n
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		_ = conn.WriteJSON(&SendBody{Cmd: "seq", Data: i})
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, i, <-seen)
+	}
+}
+
+func TestDispatchCallOnDisconnect(t *testing.T) {
+	s := NewServer(WithDispatch(DispatchConcurrent, 2))
+	calling := make(chan struct{})
+	errs := make(chan error, 1)
+	s.OnCmd("ask", func(c *MessageContext) {
+		close(calling)
+		_, err := c.Call(context.Background(), "question", nil)
+		errs <- err
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteJSON(&SendBody{Cmd: "ask"})
+	<-calling
+	_ = conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrConnectionClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Call still blocked after disconnect")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+}
@@ -0,0 +1,297 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xuzq3/glib/internal/route"
+	"github.com/xuzq3/glib/logx"
+)
+
+// engine holds the routing and dispatching shared by Server and Client.
+type engine struct {
+	msgCtxPool      *messageContextPool
+	connCtxPool     *connContextPool
+	connectHandlers []ConnHandler
+	middleware      []MessageHandler
+	routes          *route.Router
+	noRoute         []MessageHandler
+	noRouteChain    []MessageHandler
+	codec           Codec
+	codecs          map[string]Codec
+	dispatchMode    DispatchMode
+	workers         int
+	streamMu        sync.Mutex
+	streams         map[streamKey]*inStream
+	owners          map[interface{}]map[*inStream]struct{}
+	streamWg        sync.WaitGroup
+	streamTimeout   time.Duration
+	maxStreams      int
+	metrics         Metrics
+}
+
+func newEngine() engine {
+	return engine{
+		msgCtxPool:    newMessageContextPool(),
+		connCtxPool:   newConnectContextPool(),
+		routes:        route.New(),
+		codec:         NewJsonCodec(),
+		codecs:        make(map[string]Codec),
+		workers:       1,
+		streams:       make(map[streamKey]*inStream),
+		owners:        make(map[interface{}]map[*inStream]struct{}),
+		streamTimeout: defaultStreamTimeout,
+		maxStreams:    defaultMaxStreams,
+		metrics:       nopMetrics{},
+	}
+}
+
+// codecFor returns the codec registered for subprotocol, or the default
+// codec when nothing was negotiated.
+func (e *engine) codecFor(subprotocol string) Codec {
+	if codec, ok := e.codecs[subprotocol]; ok {
+		return codec
+	}
+	return e.codec
+}
+
+func (e *engine) OnConnect(handlers ...ConnHandler) {
+	e.connectHandlers = append(e.connectHandlers, handlers...)
+}
+
+// routeEntry keeps the handlers registered for a route together with the
+// chain served, which is prefixed by the global middleware.
+type routeEntry struct {
+	cmd      string
+	handlers []MessageHandler
+	chain    []MessageHandler
+}
+
+// Use adds global middleware running before the handlers of every cmd,
+// including the cmds registered before and the NoRoute handlers.
+func (e *engine) Use(middleware ...MessageHandler) {
+	all := combineHandlers(e.middleware, middleware)
+	// build every chain before updating anything, as chain may panic
+	routes := e.routes.Routes()
+	chains := make([][]MessageHandler, len(routes))
+	for i, r := range routes {
+		chains[i] = buildChain(all, r.Pattern, r.Value.(*routeEntry).handlers)
+	}
+	noRouteChain := buildChain(all, "NoRoute", e.noRoute)
+
+	e.middleware = all
+	for i, r := range routes {
+		r.Value.(*routeEntry).chain = chains[i]
+	}
+	e.noRouteChain = noRouteChain
+}
+
+// OnCmd registers handlers for cmd, replacing the handlers of a previous
+// registration. Besides exact cmds, "*" matches one dot-separated segment
+// and a trailing "**" the remaining segments, e.g. "device.*" or
+// "device.**". Exact cmds win over patterns.
+func (e *engine) OnCmd(cmd string, handlers ...MessageHandler) {
+	handlers = combineHandlers(nil, handlers)
+	e.routes.Add(cmd, &routeEntry{
+		cmd:      cmd,
+		handlers: handlers,
+		chain:    e.chain(cmd, handlers),
+	})
+}
+
+// NoRoute sets the handlers of messages whose cmd matches no route.
+// Without them such messages are dropped.
+func (e *engine) NoRoute(handlers ...MessageHandler) {
+	handlers = combineHandlers(nil, handlers)
+	e.noRouteChain = e.chain("NoRoute", handlers)
+	e.noRoute = handlers
+}
+
+// chain prefixes handlers with the global middleware. It panics when the
+// chain is too long to be served, like a duplicated route in net/http.
+func (e *engine) chain(cmd string, handlers []MessageHandler) []MessageHandler {
+	return buildChain(e.middleware, cmd, handlers)
+}
+
+// buildChain prefixes handlers with middleware and panics when the result is
+// too long to be served.
+func buildChain(middleware []MessageHandler, cmd string, handlers []MessageHandler) []MessageHandler {
+	if len(handlers) == 0 {
+		return nil
+	}
+	merged := combineHandlers(middleware, handlers)
+	if len(merged) >= int(abortIndex) {
+		panic(fmt.Sprintf("ws: too many handlers for cmd %q: %d, the limit is %d", cmd, len(merged), abortIndex-1))
+	}
+	return merged
+}
+
+// combineHandlers returns a new slice holding a followed by b, so that
+// chains built from a common prefix never share their backing array.
+func combineHandlers(a, b []MessageHandler) []MessageHandler {
+	merged := make([]MessageHandler, len(a)+len(b))
+	copy(merged, a)
+	copy(merged[len(a):], b)
+	return merged
+}
+
+// RouteInfo describes a registered route and the names of its handlers,
+// global and group middleware first.
+type RouteInfo struct {
+	Cmd      string
+	Handlers []string
+}
+
+// Routes returns the registered routes in registration order.
+func (e *engine) Routes() []RouteInfo {
+	routes := e.routes.Routes()
+	infos := make([]RouteInfo, 0, len(routes))
+	for _, r := range routes {
+		chain := r.Value.(*routeEntry).chain
+		names := make([]string, 0, len(chain))
+		for _, handler := range chain {
+			names = append(names, route.FuncName(handler))
+		}
+		infos = append(infos, RouteInfo{
+			Cmd:      r.Pattern,
+			Handlers: names,
+		})
+	}
+	return infos
+}
+
+func (e *engine) Group(handlers ...MessageHandler) *Group {
+	return &Group{
+		engine:   e,
+		handlers: combineHandlers(nil, handlers),
+	}
+}
+
+// serve runs the connect handlers and then the read loop of conn until
+// the connection fails or is closed.
+func (e *engine) serve(conn *connection) {
+	defer conn.close()
+	defer e.dropStreams(conn)
+
+	e.metrics.Connected()
+	reason := ReasonError
+	defer func() {
+		e.metrics.Disconnected(reason)
+	}()
+
+	ctx := e.connCtxPool.Get()
+	ctx.reset()
+	ctx.Conn = conn.conn
+	ctx.Request = conn.request
+	ctx.Server = conn.server
+	ctx.Client = conn.client
+	ctx.conn = conn
+	ctx.handlers = e.connectHandlers
+
+	defer e.connCtxPool.Put(ctx)
+
+	ctx.Next()
+	if ctx.IsAborted() {
+		reason = ReasonRejected
+		text := ""
+		if ctx.Error != nil {
+			text = ctx.Error.Error()
+		}
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, text)
+		_ = conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
+	}
+	conn.accept()
+
+	d := e.newDispatcher()
+	defer d.stop()
+	// workers blocked in Call only return once the pending calls fail,
+	// so the connection is closed before the dispatcher is drained
+	defer conn.close()
+
+	for {
+		messageType, message, err := conn.conn.ReadMessage()
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); ok {
+				reason = ReasonClosed
+			}
+			logx.WithError(err).Error("websocket ReadMessage failed")
+			return
+		}
+		switch messageType {
+		case websocket.TextMessage, websocket.BinaryMessage:
+			e.handleMessage(conn, d, messageType, message)
+		case websocket.CloseMessage:
+			reason = ReasonClosed
+			return
+		default:
+		}
+	}
+}
+
+func (e *engine) handleMessage(conn *connection, d *dispatcher, messageType int, message []byte) {
+	var body MessageBody
+	err := conn.codec.Decode(message, &body)
+	if err != nil {
+		logx.WithError(err).Error("websocket parseMessage failed")
+		return
+	}
+	// response to a Call initiated by this side
+	if conn.resolve(&body) {
+		return
+	}
+	if e.handleStream(conn, &body) {
+		return
+	}
+
+	ctx, err := e.parseMessage(conn, messageType, message, &body)
+	if err != nil {
+		logx.WithError(err).Error("websocket parseMessage failed")
+		return
+	}
+	d.dispatch(ctx)
+}
+
+func (e *engine) run(ctx *MessageContext) {
+	stime := time.Now()
+	defer func() {
+		e.metrics.Handled(ctx.route, time.Since(stime))
+		e.msgCtxPool.Put(ctx)
+		if r := recover(); r != nil {
+			logx.Errorf("websocket handle recover from %v", r)
+		}
+	}()
+	ctx.Next()
+}
+
+func (e *engine) parseMessage(conn *connection, messageType int, message []byte, body *MessageBody) (*MessageContext, error) {
+	var handlers []MessageHandler
+	cmd := UnknownRoute
+	if value, ok := e.routes.Match(body.Cmd); ok {
+		entry := value.(*routeEntry)
+		handlers = entry.chain
+		cmd = entry.cmd
+	} else if len(e.noRouteChain) > 0 {
+		handlers = e.noRouteChain
+	}
+	e.metrics.MessageReceived(cmd)
+	if handlers == nil {
+		return nil, errors.New("unknown cmd")
+	}
+
+	ctx := e.msgCtxPool.Get()
+	ctx.reset()
+	ctx.Conn = conn.conn
+	ctx.conn = conn
+	ctx.MessageType = messageType
+	ctx.Message = message
+	ctx.JsonBody = body
+	ctx.Server = conn.server
+	ctx.Client = conn.client
+	ctx.route = cmd
+	ctx.handlers = handlers
+	return ctx, nil
+}
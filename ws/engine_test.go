@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerRouting(t *testing.T) {
+	s := NewServer()
+	s.OnCmd("device.ping", func(c *MessageContext) {
+		_ = c.Reply("exact")
+	})
+	s.OnCmd("device.*", func(c *MessageContext) {
+		_ = c.Reply("one")
+	})
+	s.OnCmd("log.**", func(c *MessageContext) {
+		_ = c.Reply("rest")
+	})
+	s.NoRoute(func(c *MessageContext) {
+		_ = c.ReplyError(ErrUnknownCmd)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	tests := []struct {
+		cmd  string
+		code int
+		data string
+	}{
+		{"device.ping", OKCode, "exact"},
+		{"device.reboot", OKCode, "one"},
+		{"log.app.error", OKCode, "rest"},
+		{"device.a.b", ErrUnknownCmd.Code, ""},
+		{"unknown", ErrUnknownCmd.Code, ""},
+	}
+	for _, tt := range tests {
+		assert.NoError(t, conn.WriteJSON(&SendBody{Cmd: tt.cmd}))
+		var resp RespBody
+		assert.NoError(t, conn.ReadJSON(&resp))
+		assert.Equal(t, tt.cmd, resp.Cmd)
+		assert.Equal(t, tt.code, resp.Code, tt.cmd)
+		if tt.data != "" {
+			assert.Equal(t, tt.data, resp.Data, tt.cmd)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ws
+
+import "errors"
+
+const (
+	OKCode = 0
+	OKMsg  = "ok"
+)
+
+var (
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrServerClosed     = errors.New("websocket server closed")
+	ErrUnauthorized     = errors.New("unauthorized")
+)
+
+type Error struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e Error) Error() string {
+	return e.Msg
+}
+
+func NewError(code int, msg string) *Error {
+	return &Error{
+		Code: code,
+		Msg:  msg,
+	}
+}
+
+var (
+	ErrServerError       = NewError(10000, "server error")
+	ErrInvalidConnection = NewError(10001, "invalid connection")
+	ErrHandleTimeout     = NewError(10002, "handle timeout")
+	ErrInvalidParams     = NewError(10003, "invalid params")
+	ErrTooManyRequests   = NewError(10004, "too many requests")
+	ErrUnknownCmd        = NewError(10005, "unknown cmd")
+	ErrStreamNotFound    = NewError(10006, "stream not found")
+	ErrStreamOffset      = NewError(10007, "stream offset mismatch")
+	ErrStreamChecksum    = NewError(10008, "stream checksum mismatch")
+	ErrStreamTimeout     = NewError(10009, "stream timeout")
+	ErrTooManyStreams    = NewError(10010, "too many streams")
+	ErrStreamOverflow    = NewError(10011, "stream buffer overflow")
+)
@@ -0,0 +1,30 @@
+package ginws
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/xuzq3/glib/ws"
+)
+
+// Handler mounts s as a gin handler, so gin middleware runs before the
+// upgrade and can reject the request by aborting. Keys set on the gin
+// context, such as the user ID or request ID, are copied to the session of
+// the connection. When keys are given only those are copied.
+func Handler(s *ws.Server, keys ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		values := make(map[string]interface{})
+		if len(keys) == 0 {
+			for key, value := range c.Keys {
+				values[key] = value
+			}
+		} else {
+			for _, key := range keys {
+				if value, ok := c.Get(key); ok {
+					values[key] = value
+				}
+			}
+		}
+
+		s.UpgradeWithKeys(c.Writer, c.Request, values)
+		c.Abort()
+	}
+}
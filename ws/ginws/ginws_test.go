@@ -0,0 +1,47 @@
+package ginws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/ws"
+)
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := ws.NewServer()
+	userID := make(chan string, 1)
+	s.OnConnect(func(c *ws.ConnContext) {
+		userID <- c.Session().GetString("userID")
+	})
+
+	r := gin.New()
+	r.GET("/ws", func(c *gin.Context) {
+		if c.Query("uid") == "" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set("userID", c.Query("uid"))
+		c.Set("ignored", true)
+	}, Handler(s, "userID"))
+	hs := httptest.NewServer(r)
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?uid=42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "42", <-userID)
+}
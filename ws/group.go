@@ -1,17 +1,22 @@
 package ws
 
 type Group struct {
-	server   *Server
+	engine   *engine
 	handlers []MessageHandler
 }
 
+// Use adds middleware to the cmds registered on the group afterwards.
+func (g *Group) Use(middleware ...MessageHandler) {
+	g.handlers = combineHandlers(g.handlers, middleware)
+}
+
 func (g *Group) OnCmd(cmd string, handlers ...MessageHandler) {
-	g.server.messageHandlers[cmd] = append(g.handlers, handlers...)
+	g.engine.OnCmd(cmd, combineHandlers(g.handlers, handlers)...)
 }
 
 func (g *Group) Group(handlers ...MessageHandler) *Group {
 	return &Group{
-		server:   g.server,
-		handlers: append(g.handlers, handlers...),
+		engine:   g.engine,
+		handlers: combineHandlers(g.handlers, handlers),
 	}
 }
@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/internal/route"
+)
+
+func handlerA(c *MessageContext) {}
+func handlerB(c *MessageContext) {}
+func handlerC(c *MessageContext) {}
+func handlerD(c *MessageContext) {}
+
+func routeHandlers(s *Server, cmd string) []string {
+	for _, r := range s.Routes() {
+		if r.Cmd == cmd {
+			return r.Handlers
+		}
+	}
+	return nil
+}
+
+func TestGroupChaining(t *testing.T) {
+	s := NewServer()
+	g := s.Group(handlerA)
+	g.Use(handlerB)
+	sub1 := g.Group(handlerC)
+	sub2 := g.Group(handlerD)
+	sub1.OnCmd("one", handlerA)
+	sub2.OnCmd("two", handlerB)
+	s.Use(handlerD)
+
+	name := func(h MessageHandler) string {
+		return route.FuncName(h)
+	}
+	assert.Equal(t, []string{name(handlerD), name(handlerA), name(handlerB), name(handlerC), name(handlerA)}, routeHandlers(s, "one"))
+	assert.Equal(t, []string{name(handlerD), name(handlerA), name(handlerB), name(handlerD), name(handlerB)}, routeHandlers(s, "two"))
+}
+
+func TestTooManyHandlers(t *testing.T) {
+	s := NewServer()
+	handlers := make([]MessageHandler, abortIndex)
+	for i := range handlers {
+		handlers[i] = handlerA
+	}
+	assert.Panics(t, func() {
+		s.OnCmd("many", handlers...)
+	})
+	assert.NotPanics(t, func() {
+		s.OnCmd("many", handlers[1:]...)
+	})
+	s.OnCmd("few", handlerC)
+	assert.Panics(t, func() {
+		s.Use(handlerB)
+	})
+	// a failed Use leaves the server unchanged
+	assert.Empty(t, s.middleware)
+	assert.Equal(t, []string{route.FuncName(handlerC)}, routeHandlers(s, "few"))
+	assert.Len(t, routeHandlers(s, "many"), int(abortIndex)-1)
+}
@@ -0,0 +1,229 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/xuzq3/glib/logx"
+)
+
+const (
+	defaultBrokerMinBackoff = time.Millisecond * 100
+	defaultBrokerMaxBackoff = time.Second * 10
+	defaultBroadcastTimeout = time.Second * 5
+)
+
+const (
+	targetAll  = "all"
+	targetUser = "user"
+	targetRoom = "room"
+)
+
+// broadcastMessage is the payload exchanged through the broker.
+type broadcastMessage struct {
+	Target string          `json:"target"`
+	Key    string          `json:"key,omitempty"`
+	Cmd    string          `json:"cmd"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// hub indexes the connections of a server by user and room. all holds the
+// connections accepted by the connect handlers, which broadcasts reach.
+type hub struct {
+	mu    sync.RWMutex
+	all   map[*connection]struct{}
+	users map[string]map[*connection]struct{}
+	rooms map[string]map[*connection]struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		all:   make(map[*connection]struct{}),
+		users: make(map[string]map[*connection]struct{}),
+		rooms: make(map[string]map[*connection]struct{}),
+	}
+}
+
+func (h *hub) add(c *connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.all[c] = struct{}{}
+}
+
+func (h *hub) bindUser(c *connection, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.user != "" {
+		removeConn(h.users, c.user, c)
+	}
+	c.user = userID
+	addConn(h.users, userID, c)
+}
+
+func (h *hub) join(c *connection, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.rooms == nil {
+		c.rooms = make(map[string]struct{})
+	}
+	c.rooms[room] = struct{}{}
+	addConn(h.rooms, room, c)
+}
+
+func (h *hub) leave(c *connection, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(c.rooms, room)
+	removeConn(h.rooms, room, c)
+}
+
+func (h *hub) remove(c *connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.all, c)
+	if c.user != "" {
+		removeConn(h.users, c.user, c)
+	}
+	for room := range c.rooms {
+		removeConn(h.rooms, room, c)
+	}
+}
+
+func (h *hub) lookup(index map[string]map[*connection]struct{}, key string) []*connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	conns := make([]*connection, 0, len(index[key]))
+	for c := range index[key] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func addConn(index map[string]map[*connection]struct{}, key string, c *connection) {
+	conns, ok := index[key]
+	if !ok {
+		conns = make(map[*connection]struct{})
+		index[key] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+func removeConn(index map[string]map[*connection]struct{}, key string, c *connection) {
+	conns, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(index, key)
+	}
+}
+
+// Broadcast sends cmd to every connection, on every instance sharing the
+// broker.
+func (s *Server) Broadcast(ctx context.Context, cmd string, data interface{}) error {
+	return s.publish(ctx, targetAll, "", cmd, data)
+}
+
+// SendTo sends cmd to every connection bound to userID, see
+// ConnContext.BindUser.
+func (s *Server) SendTo(ctx context.Context, userID string, cmd string, data interface{}) error {
+	return s.publish(ctx, targetUser, userID, cmd, data)
+}
+
+// SendToRoom sends cmd to every connection that joined room.
+func (s *Server) SendToRoom(ctx context.Context, room string, cmd string, data interface{}) error {
+	return s.publish(ctx, targetRoom, room, cmd, data)
+}
+
+func (s *Server) publish(ctx context.Context, target string, key string, cmd string, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	msg := &broadcastMessage{
+		Target: target,
+		Key:    key,
+		Cmd:    cmd,
+		Data:   js,
+	}
+
+	if s.broker == nil {
+		s.deliver(msg)
+		return nil
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.broker.Publish(ctx, payload)
+}
+
+// subscribe runs the subscription to the broker until ctx is done,
+// subscribing again with exponential backoff whenever it fails.
+func (s *Server) subscribe(ctx context.Context) {
+	backoff := defaultBrokerMinBackoff
+	for {
+		start := time.Now()
+		err := s.broker.Subscribe(ctx, func(payload []byte) {
+			var msg broadcastMessage
+			err := json.Unmarshal(payload, &msg)
+			if err != nil {
+				logx.WithError(err).Error("websocket broadcast message invalid")
+				return
+			}
+			s.deliver(&msg)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logx.WithError(err).Error("websocket broker subscribe failed")
+		}
+
+		// a subscription that lasted is not failing repeatedly
+		if time.Since(start) > defaultBrokerMaxBackoff {
+			backoff = defaultBrokerMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > defaultBrokerMaxBackoff {
+			backoff = defaultBrokerMaxBackoff
+		}
+	}
+}
+
+func (s *Server) deliver(msg *broadcastMessage) {
+	var conns []*connection
+	switch msg.Target {
+	case targetAll:
+		s.hub.mu.RLock()
+		conns = make([]*connection, 0, len(s.hub.all))
+		for c := range s.hub.all {
+			conns = append(conns, c)
+		}
+		s.hub.mu.RUnlock()
+	case targetUser:
+		conns = s.hub.lookup(s.hub.users, msg.Key)
+	case targetRoom:
+		conns = s.hub.lookup(s.hub.rooms, msg.Key)
+	}
+
+	body := &SendBody{
+		Cmd:  msg.Cmd,
+		Data: msg.Data,
+	}
+	// a stuck connection must not stall the broadcasts of the others
+	for _, c := range conns {
+		err := c.writeBodyDeadline(body, time.Now().Add(s.broadcastTimeout))
+		if err != nil {
+			logx.WithError(err).Error("websocket broadcast write failed")
+		}
+	}
+}
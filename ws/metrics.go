@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"time"
+)
+
+// Disconnect reasons reported to Metrics.
+const (
+	// ReasonClosed is a close handshake initiated by either side.
+	ReasonClosed = "closed"
+	// ReasonError is a connection lost without close handshake.
+	ReasonError = "error"
+	// ReasonRejected is a connection aborted by the connect handlers.
+	ReasonRejected = "rejected"
+)
+
+// UnknownRoute is the cmd reported to Metrics for messages matching no
+// route.
+const UnknownRoute = "unknown"
+
+// Metrics receives the events of a Server or Client. Cmds are reported by
+// their route pattern, so that clients can't create unbounded label sets:
+// the messages written by handlers, replies included, are reported under
+// the route of the message handled.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	Connected()
+	Disconnected(reason string)
+	MessageReceived(cmd string)
+	MessageSent(cmd string)
+	Handled(cmd string, latency time.Duration)
+	// Queued reports the change of the number of messages waiting for a
+	// dispatch worker.
+	Queued(delta int)
+}
+
+// WithMetrics sets the metrics receiving the events of the server, see
+// NewPrometheusMetrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(s *Server) {
+		s.metrics = metrics
+	}
+}
+
+var _ Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Connected()                    {}
+func (nopMetrics) Disconnected(string)           {}
+func (nopMetrics) MessageReceived(string)        {}
+func (nopMetrics) MessageSent(string)            {}
+func (nopMetrics) Handled(string, time.Duration) {}
+func (nopMetrics) Queued(int)                    {}
+
+// bodyCmd returns the cmd of an outbound envelope.
+func bodyCmd(v interface{}) string {
+	switch body := v.(type) {
+	case *SendBody:
+		return body.Cmd
+	case SendBody:
+		return body.Cmd
+	case *RespBody:
+		return body.Cmd
+	case RespBody:
+		return body.Cmd
+	case *MessageBody:
+		return body.Cmd
+	default:
+		return ""
+	}
+}
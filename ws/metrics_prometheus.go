@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the handler latency
+// histogram.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+var _ http.Handler = (*PrometheusMetrics)(nil)
+
+// PrometheusMetrics collects Metrics in memory and serves them in the
+// Prometheus text exposition format.
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	mu          sync.Mutex
+	active      int64
+	connects    int64
+	disconnects map[string]int64
+	received    map[string]int64
+	sent        map[string]int64
+	latencies   map[string]*histogram
+	queued      int64
+}
+
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// NewPrometheusMetrics returns metrics named with namespace as prefix, e.g.
+// "ws" exposes ws_connections_active.
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		namespace:   namespace,
+		buckets:     DefaultBuckets,
+		disconnects: make(map[string]int64),
+		received:    make(map[string]int64),
+		sent:        make(map[string]int64),
+		latencies:   make(map[string]*histogram),
+	}
+}
+
+func (m *PrometheusMetrics) Connected() {
+	m.mu.Lock()
+	m.active++
+	m.connects++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) Disconnected(reason string) {
+	m.mu.Lock()
+	m.active--
+	m.disconnects[reason]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) MessageReceived(cmd string) {
+	m.mu.Lock()
+	m.received[cmd]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) MessageSent(cmd string) {
+	m.mu.Lock()
+	m.sent[cmd]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) Handled(cmd string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latencies[cmd]
+	if !ok {
+		h = &histogram{
+			counts: make([]int64, len(m.buckets)),
+		}
+		m.latencies[cmd] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *PrometheusMetrics) Queued(delta int) {
+	m.mu.Lock()
+	m.queued += int64(delta)
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) Write(out io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	name := func(s string) string {
+		if m.namespace == "" {
+			return s
+		}
+		return m.namespace + "_" + s
+	}
+
+	writeHeader(w, name("connections_active"), "gauge", "Number of open connections.")
+	fmt.Fprintf(w, "%s %d\n", name("connections_active"), m.active)
+
+	writeHeader(w, name("connects_total"), "counter", "Number of accepted connections.")
+	fmt.Fprintf(w, "%s %d\n", name("connects_total"), m.connects)
+
+	writeHeader(w, name("disconnects_total"), "counter", "Number of closed connections by reason.")
+	writeCounters(w, name("disconnects_total"), "reason", m.disconnects)
+
+	writeHeader(w, name("messages_received_total"), "counter", "Number of received messages by cmd.")
+	writeCounters(w, name("messages_received_total"), "cmd", m.received)
+
+	writeHeader(w, name("messages_sent_total"), "counter", "Number of sent messages by cmd.")
+	writeCounters(w, name("messages_sent_total"), "cmd", m.sent)
+
+	writeHeader(w, name("handle_duration_seconds"), "histogram", "Latency of message handlers by cmd.")
+	for _, cmd := range sortedKeys(m.latencies) {
+		h := m.latencies[cmd]
+		label := escapeLabel(cmd)
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket{cmd=\"%s\",le=\"%g\"} %d\n", name("handle_duration_seconds"), label, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{cmd=\"%s\",le=\"+Inf\"} %d\n", name("handle_duration_seconds"), label, h.count)
+		fmt.Fprintf(w, "%s_sum{cmd=\"%s\"} %g\n", name("handle_duration_seconds"), label, h.sum)
+		fmt.Fprintf(w, "%s_count{cmd=\"%s\"} %d\n", name("handle_duration_seconds"), label, h.count)
+	}
+
+	writeHeader(w, name("queue_depth"), "gauge", "Number of messages waiting for a dispatch worker.")
+	fmt.Fprintf(w, "%s %d\n", name("queue_depth"), m.queued)
+
+	return w.Flush()
+}
+
+func writeHeader(w io.Writer, name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeCounters(w io.Writer, name string, label string, counters map[string]int64) {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), counters[key])
+	}
+}
+
+func sortedKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
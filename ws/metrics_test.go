@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics("ws")
+	m.Connected()
+	m.Connected()
+	m.Disconnected(ReasonClosed)
+	m.MessageReceived("device.*")
+	m.MessageSent(`say "hi"`)
+	m.Handled("device.*", time.Millisecond*20)
+	m.Queued(2)
+	m.Queued(-1)
+
+	var buf bytes.Buffer
+	assert.NoError(t, m.Write(&buf))
+	out := buf.String()
+
+	for _, line := range []string{
+		"ws_connections_active 1",
+		"ws_connects_total 2",
+		`ws_disconnects_total{reason="closed"} 1`,
+		`ws_messages_received_total{cmd="device.*"} 1`,
+		`ws_messages_sent_total{cmd="say \"hi\""} 1`,
+		`ws_handle_duration_seconds_bucket{cmd="device.*",le="0.01"} 0`,
+		`ws_handle_duration_seconds_bucket{cmd="device.*",le="0.025"} 1`,
+		`ws_handle_duration_seconds_bucket{cmd="device.*",le="+Inf"} 1`,
+		`ws_handle_duration_seconds_count{cmd="device.*"} 1`,
+		"ws_queue_depth 1",
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := NewPrometheusMetrics("ws")
+	s := NewServer(WithMetrics(m))
+	s.OnCmd("device.*", func(c *MessageContext) {
+		_ = c.Reply(nil)
+	})
+	s.NoRoute(func(c *MessageContext) {
+		_ = c.ReplyError(ErrUnknownCmd)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteJSON(&SendBody{Cmd: "device.reboot"})
+	_ = conn.WriteJSON(&SendBody{Cmd: "nothing"})
+	var resp RespBody
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.NoError(t, conn.ReadJSON(&resp))
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_, _, _ = conn.ReadMessage()
+	_ = conn.Close()
+
+	assert.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		out := rec.Body.String()
+		return strings.Contains(out, `ws_disconnects_total{reason="closed"} 1`) &&
+			strings.Contains(out, `ws_messages_received_total{cmd="device.*"} 1`) &&
+			strings.Contains(out, `ws_messages_received_total{cmd="unknown"} 1`) &&
+			strings.Contains(out, `ws_messages_sent_total{cmd="device.*"} 1`) &&
+			strings.Contains(out, `ws_messages_sent_total{cmd="unknown"} 1`) &&
+			!strings.Contains(out, `cmd="device.reboot"`) &&
+			!strings.Contains(out, `cmd="nothing"`) &&
+			strings.Contains(out, `ws_handle_duration_seconds_count{cmd="device.*"} 1`)
+	}, time.Second, time.Millisecond*10)
+}
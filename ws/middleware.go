@@ -2,9 +2,31 @@ package ws
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"time"
+
+	"github.com/xuzq3/glib/logx"
 )
 
+func Log() MessageHandler {
+	return func(c *MessageContext) {
+		stime := time.Now()
+		logx.Infof("websocket serve from:%s cmd:%s seq:%s data:%s",
+			c.Conn.RemoteAddr().String(), c.JsonBody.Cmd, c.JsonBody.Seqno, string(c.JsonBody.Data))
+
+		c.Next()
+
+		if c.Error != nil {
+			logx.Errorf("websocket serve failed cmd:%s seq:%s latency:%v err:%s",
+				c.JsonBody.Cmd, c.JsonBody.Seqno, time.Since(stime), c.Error.Error())
+		} else {
+			logx.Debugf("websocket serve success cmd:%s seq:%s latency:%v",
+				c.JsonBody.Cmd, c.JsonBody.Seqno, time.Since(stime))
+		}
+	}
+}
+
 func Timeout() MessageHandler {
 	return func(c *MessageContext) {
 		ctx, cancel := context.WithTimeout(c.Context(), time.Minute*5)
@@ -19,15 +41,67 @@ func Timeout() MessageHandler {
 	}
 }
 
-//func Recovery() Handler {
-//	return func(c *MessageContext) {
-//		defer func() {
-//			if r := recover(); r != nil {
-//				err := fmt.Errorf("panic: %v", r)
-//				c.AbortWithError(err)
-//			}
-//		}()
-//
-//		c.Next()
-//	}
-//}
+func Recovery() MessageHandler {
+	return func(c *MessageContext) {
+		defer func() {
+			if r := recover(); r != nil {
+				err := fmt.Errorf("panic: %v", r)
+				c.AbortWithError(err)
+				_ = c.ReplyError(ErrServerError)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// rateLimit is the configuration of a RateLimit middleware, each
+// connection keeps its own ratelimit.Limiter for it, with a bucket per
+// route.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// RateLimit limits every route of a connection to rate messages per second
+// with bursts of burst messages. Routes are the patterns matched, so all
+// cmds of "device.*" share a limit, as do the cmds served by NoRoute.
+// Messages over the limit are answered with ErrTooManyRequests and not
+// handled.
+func RateLimit(rate float64, burst int) MessageHandler {
+	limit := &rateLimit{
+		rate:  rate,
+		burst: burst,
+	}
+	return func(c *MessageContext) {
+		limiter := c.conn.limiter(limit)
+		if !limiter.Allow(c.route, time.Now()) {
+			c.AbortWithError(ErrTooManyRequests)
+			_ = c.ReplyError(ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Validate binds the payload of every message into a new value of the type
+// of obj and validates it with its binding tags. Invalid messages are
+// answered with ErrInvalidParams, otherwise the value is available to the
+// handlers through MessageContext.Params.
+func Validate(obj interface{}) MessageHandler {
+	typ := reflect.TypeOf(obj)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return func(c *MessageContext) {
+		params := reflect.New(typ).Interface()
+		err := c.ShouldBind(params)
+		if err != nil {
+			c.AbortWithError(err)
+			_ = c.ReplyError(NewError(ErrInvalidParams.Code, err.Error()))
+			return
+		}
+		c.params = params
+		c.Next()
+	}
+}
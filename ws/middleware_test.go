@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryAndValidate(t *testing.T) {
+	type req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	s := NewServer()
+	g := s.Group(Recovery())
+	g.OnCmd("panic", func(c *MessageContext) {
+		panic("boom")
+	})
+	g.OnCmd("hello", Validate(req{}), func(c *MessageContext) {
+		_ = c.Reply("hello " + c.Params().(*req).Name)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var resp RespBody
+	_ = conn.WriteJSON(&SendBody{Cmd: "panic"})
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, ErrServerError.Code, resp.Code)
+
+	_ = conn.WriteJSON(&SendBody{Cmd: "hello", Data: map[string]string{}})
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, ErrInvalidParams.Code, resp.Code)
+
+	_ = conn.WriteJSON(&SendBody{Cmd: "hello", Data: map[string]string{"name": "ws"}})
+	assert.NoError(t, conn.ReadJSON(&resp))
+	assert.Equal(t, OKCode, resp.Code)
+	assert.Equal(t, "hello ws", resp.Data)
+}
+
+func TestRateLimitByRoute(t *testing.T) {
+	s := NewServer()
+	s.Use(RateLimit(0.001, 2))
+	s.OnCmd("device.*", func(c *MessageContext) {
+		_ = c.Reply(nil)
+	})
+	s.NoRoute(func(c *MessageContext) {
+		_ = c.Reply(nil)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// changing the cmd doesn't start a new burst
+	for _, cmds := range [][]string{{"device.a", "device.b", "device.c"}, {"x", "y", "z"}} {
+		for i, cmd := range cmds {
+			_ = conn.WriteJSON(&SendBody{Cmd: cmd})
+			var resp RespBody
+			assert.NoError(t, conn.ReadJSON(&resp))
+			if i < 2 {
+				assert.Equal(t, OKCode, resp.Code, cmd)
+			} else {
+				assert.Equal(t, ErrTooManyRequests.Code, resp.Code, cmd)
+			}
+		}
+	}
+}
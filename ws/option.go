@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Option func(s *Server)
+
+// WithCheckOrigin sets the function validating the Origin header of upgrade
+// requests. By default every origin is accepted.
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(s *Server) {
+		s.upgrader.CheckOrigin = fn
+	}
+}
+
+// WithAllowedOrigins only accepts upgrade requests whose Origin matches one
+// of origins, given either as full origin ("https://example.com") or as
+// host ("example.com", "*.example.com"). A host matches any port unless
+// it has one ("example.com:8443"). Requests without Origin header, which
+// don't come from browsers, are accepted.
+func WithAllowedOrigins(origins ...string) Option {
+	allowed := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		allowed = append(allowed, strings.ToLower(origin))
+	}
+	return WithCheckOrigin(func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		origin = strings.ToLower(origin)
+		host := strings.ToLower(u.Host)
+		hostname := strings.ToLower(u.Hostname())
+		for _, a := range allowed {
+			if a == origin || a == host || a == hostname {
+				return true
+			}
+			if strings.HasPrefix(a, "*.") && strings.HasSuffix(hostname, a[1:]) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithSubprotocols sets the subprotocols supported by the server, in order
+// of preference. Subprotocols of codecs added with AddCodec are appended.
+func WithSubprotocols(protocols ...string) Option {
+	return func(s *Server) {
+		s.upgrader.Subprotocols = append(s.upgrader.Subprotocols, protocols...)
+	}
+}
+
+// WithCompression negotiates permessage-deflate and compresses written
+// messages with level, see compress/flate.
+func WithCompression(level int) Option {
+	return func(s *Server) {
+		s.upgrader.EnableCompression = true
+		s.compressionLevel = level
+	}
+}
+
+func WithBufferSize(readBufferSize, writeBufferSize int) Option {
+	return func(s *Server) {
+		s.upgrader.ReadBufferSize = readBufferSize
+		s.upgrader.WriteBufferSize = writeBufferSize
+	}
+}
+
+// WithResponseHeader sets headers added to every upgrade response, such as
+// Set-Cookie. Sec-WebSocket-Protocol is negotiated from the subprotocols
+// and must not be set here.
+func WithResponseHeader(header http.Header) Option {
+	return func(s *Server) {
+		s.responseHeader = header
+	}
+}
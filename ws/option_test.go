@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAllowedOrigins(t *testing.T) {
+	s := NewServer(WithAllowedOrigins("https://app.example.com", "*.example.org", "example.net", "example.io:8443"))
+	check := s.upgrader.CheckOrigin
+
+	cases := map[string]bool{
+		"":                         true,
+		"https://app.example.com":  true,
+		"http://app.example.com":   false,
+		"https://evil.com":         false,
+		"https://a.example.org":    true,
+		"https://example.org.evil": false,
+		"https://a.example.org:80": true,
+		"https://example.net":      true,
+		"http://example.net:8080":  true,
+		"https://example.io:8443":  true,
+		"https://example.io":       false,
+	}
+	for origin, want := range cases {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		assert.Equal(t, want, check(r), origin)
+	}
+}
+
+// echoServer starts s with an echo handler and dials it with dialer.
+func echoServer(t *testing.T, s *Server, dialer *websocket.Dialer) (*websocket.Conn, *http.Response, func()) {
+	s.OnCmd("echo", func(c *MessageContext) {
+		_ = c.Reply(c.JsonBody.Data)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		hs.Close()
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	return conn, resp, func() {
+		_ = conn.Close()
+		hs.Close()
+	}
+}
+
+// echo sends data to the echo handler and returns the data replied.
+func echo(t *testing.T, conn *websocket.Conn, data string) string {
+	assert.NoError(t, conn.WriteJSON(&SendBody{Cmd: "echo", Seqno: "1", Data: data}))
+	var resp struct {
+		Data string `json:"data"`
+	}
+	assert.NoError(t, conn.ReadJSON(&resp))
+	return resp.Data
+}
+
+func TestWithCompression(t *testing.T) {
+	s := NewServer(WithCompression(9))
+	conn, resp, done := echoServer(t, s, &websocket.Dialer{EnableCompression: true})
+	defer done()
+
+	assert.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	data := strings.Repeat("compressible ", 1000)
+	assert.Equal(t, data, echo(t, conn, data))
+}
+
+func TestWithBufferSize(t *testing.T) {
+	s := NewServer(WithBufferSize(128, 256))
+	assert.Equal(t, 128, s.upgrader.ReadBufferSize)
+	assert.Equal(t, 256, s.upgrader.WriteBufferSize)
+
+	// messages larger than the buffers still go through
+	conn, _, done := echoServer(t, s, websocket.DefaultDialer)
+	defer done()
+	data := strings.Repeat("x", 4096)
+	assert.Equal(t, data, echo(t, conn, data))
+}
+
+func TestWithResponseHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Server-Id", "node-1")
+	s := NewServer(WithResponseHeader(header))
+	_, resp, done := echoServer(t, s, websocket.DefaultDialer)
+	defer done()
+
+	assert.Equal(t, "node-1", resp.Header.Get("X-Server-Id"))
+}
@@ -0,0 +1,46 @@
+package ws
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// Peer is a handle on a single connection that stays valid after the
+// handler returns. ConnContext and MessageContext are recycled once their
+// handlers return, so goroutines outliving a handler must use the Peer of
+// the context instead of the context itself.
+type Peer struct {
+	conn *connection
+}
+
+func (p *Peer) WriteMessage(data []byte) error {
+	return p.conn.writeMessage(websocket.TextMessage, data)
+}
+
+func (p *Peer) WriteJson(v interface{}) error {
+	return p.conn.writeJson(v)
+}
+
+// WriteBody encodes v, typically a SendBody or RespBody, with the codec of
+// the connection.
+func (p *Peer) WriteBody(v interface{}) error {
+	return p.conn.writeBody(v)
+}
+
+// Call sends cmd to the peer and blocks until the response with the same
+// seqno arrives, ctx expires or the connection is closed, in which case it
+// returns ErrConnectionClosed.
+func (p *Peer) Call(ctx context.Context, cmd string, data interface{}) (*RespBody, error) {
+	return p.conn.call(ctx, cmd, data)
+}
+
+// OpenStream starts a chunked transfer to the handlers of cmd on the peer,
+// see MessageContext.OpenStream.
+func (p *Peer) OpenStream(ctx context.Context, cmd string, id string, size int64, meta interface{}) (*StreamWriter, error) {
+	return p.conn.openStream(ctx, cmd, id, size, meta)
+}
+
+func (p *Peer) Session() *Session {
+	return p.conn.session
+}
@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerCallAfterDisconnect(t *testing.T) {
+	s := NewServer()
+	peers := make(chan *Peer, 2)
+	s.OnConnect(func(c *ConnContext) {
+		peers <- c.Peer()
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	connA, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-peers
+	_ = connA.Close()
+
+	// the connect context of A is reused for B
+	connB, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connB.Close()
+	<-peers
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err = peer.Call(ctx, "question", nil)
+		cancel()
+		if err == ErrConnectionClosed || time.Now().After(deadline) {
+			break
+		}
+	}
+	assert.Equal(t, ErrConnectionClosed, err)
+
+	_ = connB.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = connB.ReadMessage()
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "timeout"))
+}
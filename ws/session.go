@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Session is the key/value store of a connection, shared by its
+// ConnContext and every MessageContext.
+type Session struct {
+	mu   sync.RWMutex
+	keys map[string]interface{}
+}
+
+func newSession() *Session {
+	return &Session{
+		keys: make(map[string]interface{}),
+	}
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	s.keys[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.keys[key]
+	return value, ok
+}
+
+func (s *Session) MustGet(key string) interface{} {
+	value, ok := s.Get(key)
+	if !ok {
+		panic(fmt.Sprintf("key %q does not exist", key))
+	}
+	return value
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	delete(s.keys, key)
+	s.mu.Unlock()
+}
+
+func (s *Session) GetString(key string) string {
+	value, _ := s.Get(key)
+	v, _ := value.(string)
+	return v
+}
+
+func (s *Session) GetInt(key string) int {
+	value, _ := s.Get(key)
+	v, _ := value.(int)
+	return v
+}
+
+func (s *Session) GetInt64(key string) int64 {
+	value, _ := s.Get(key)
+	v, _ := value.(int64)
+	return v
+}
+
+func (s *Session) GetBool(key string) bool {
+	value, _ := s.Get(key)
+	v, _ := value.(bool)
+	return v
+}
@@ -0,0 +1,649 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xuzq3/glib/logx"
+)
+
+// Reserved cmds of the chunked transfer protocol. A stream is opened with
+// a StreamStartCmd call naming the target cmd, carried by StreamChunkCmd
+// messages and finished by a StreamEndCmd call. The receiver answers the
+// start call with the offset already received, so a sender reconnecting
+// with the same stream ID and owner resumes where it stopped. The owner is
+// the identity of an authenticated connection on the server and the
+// Client itself on the client; streams without owner end with their
+// connection. Every
+// streamAckChunks-th chunk is sent as call, answered once the handler
+// consumed it, which bounds the data buffered by the receiver.
+const (
+	StreamStartCmd = "stream.start"
+	StreamChunkCmd = "stream.chunk"
+	StreamEndCmd   = "stream.end"
+)
+
+const (
+	defaultChunkSize     = 32 * 1024
+	defaultStreamTimeout = time.Minute * 2
+	defaultMaxStreams    = 4
+	// streamAckChunks is the number of chunks a StreamWriter sends before
+	// waiting for the peer to consume them.
+	streamAckChunks = 4
+	// streamBufferSize bounds the data an incoming stream buffers for its
+	// handler. A sender not waiting for the acks overflows it.
+	streamBufferSize = 2 * streamAckChunks * defaultChunkSize
+)
+
+type streamStart struct {
+	ID   string          `json:"id"`
+	Cmd  string          `json:"cmd"`
+	Size int64           `json:"size"`
+	Meta json.RawMessage `json:"meta,omitempty"`
+}
+
+type streamChunk struct {
+	ID       string `json:"id"`
+	Offset   int64  `json:"offset"`
+	Checksum uint32 `json:"checksum"`
+	Data     []byte `json:"data"`
+}
+
+type streamEnd struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+type streamState struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// WithStreamTimeout sets how long an incoming stream waits for its next
+// chunk, including the time for the sender to reconnect, before it fails
+// with ErrStreamTimeout.
+func WithStreamTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.streamTimeout = timeout
+	}
+}
+
+// WithMaxStreams sets how many incoming streams an owner, that is an
+// identity or an unauthenticated connection, may have open at once,
+// including those waiting to be resumed. Further starts fail with
+// ErrTooManyStreams.
+func WithMaxStreams(n int) Option {
+	return func(s *Server) {
+		s.maxStreams = n
+	}
+}
+
+// Stream is the payload of an incoming chunked transfer, read by the
+// handler of its cmd through MessageContext.Stream.
+type Stream struct {
+	ID string
+	// Size is the announced size, or -1 when unknown.
+	Size int64
+	// Meta is the undecoded meta data sent with the stream.
+	Meta json.RawMessage
+
+	in *inStream
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	return s.in.read(p)
+}
+
+// streamKey identifies a stream by its ID and owner, see streamOwner.
+type streamKey struct {
+	owner interface{}
+	id    string
+}
+
+// streamOwner returns the peer whose streams survive the reconnects of
+// conn: the Client of a client connection, the identity of an
+// authenticated server connection, or conn itself. Identities are compared
+// with ==, so they should be values such as a user ID.
+func streamOwner(conn *connection) interface{} {
+	if conn.client != nil {
+		return conn.client
+	}
+	identity, ok := conn.session.Get(IdentityKey)
+	if ok && identity != nil && reflect.TypeOf(identity).Comparable() {
+		return identity
+	}
+	return conn
+}
+
+// inStream is the receiving side of a stream. The read loop appends the
+// chunks to a bounded buffer drained by the handler.
+type inStream struct {
+	key     streamKey
+	stream  *Stream
+	timer   *time.Timer
+	timeout time.Duration
+	// conn is the connection currently sending the stream, guarded by
+	// engine.streamMu.
+	conn *connection
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	offset   int64
+	consumed int64
+	acks     []streamAck
+	err      error
+	done     bool
+}
+
+// streamAck is a chunk sent as call, answered once the handler consumed
+// the data up to offset.
+type streamAck struct {
+	conn   *connection
+	seqno  string
+	offset int64
+}
+
+func (s *inStream) read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		err := s.err
+		s.mu.Unlock()
+		return 0, err
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	s.consumed += int64(n)
+	acks := s.takeAcks()
+	// a slow handler must not time the stream out
+	if s.err == nil {
+		s.timer.Reset(s.timeout)
+	}
+	s.mu.Unlock()
+
+	for _, ack := range acks {
+		s.reply(ack, nil)
+	}
+	return n, nil
+}
+
+// takeAcks returns the acks of the chunks consumed by the handler. It must
+// be called with mu held.
+func (s *inStream) takeAcks() []streamAck {
+	var acks []streamAck
+	i := 0
+	for ; i < len(s.acks) && s.acks[i].offset <= s.consumed; i++ {
+		acks = append(acks, s.acks[i])
+	}
+	s.acks = s.acks[i:]
+	return acks
+}
+
+func (s *inStream) reply(ack streamAck, failure *Error) {
+	body := &MessageBody{Cmd: StreamChunkCmd, Seqno: ack.seqno}
+	err := replyStream(ack.conn, body, failure, &streamState{ID: s.stream.ID, Offset: ack.offset})
+	if err != nil {
+		logx.WithError(err).Error("websocket stream ack failed")
+	}
+}
+
+// close ends the data of the stream with err once the buffer is drained
+// and fails the chunks waiting for an ack.
+func (s *inStream) close(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.timer.Stop()
+	acks := s.acks
+	s.acks = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	failure, ok := err.(*Error)
+	if !ok {
+		failure = ErrStreamNotFound
+	}
+	for _, ack := range acks {
+		s.reply(ack, failure)
+	}
+}
+
+// ownedStream returns the stream id of conn, if conn is the connection
+// currently sending it.
+func (e *engine) ownedStream(conn *connection, id string) (*inStream, bool) {
+	key := streamKey{owner: streamOwner(conn), id: id}
+	e.streamMu.Lock()
+	defer e.streamMu.Unlock()
+	s, ok := e.streams[key]
+	if !ok || s.conn != conn {
+		return nil, false
+	}
+	return s, true
+}
+
+// addStream indexes s by key and owner. It must be called with streamMu
+// held.
+func (e *engine) addStream(s *inStream) {
+	owned, ok := e.owners[s.key.owner]
+	if !ok {
+		owned = make(map[*inStream]struct{})
+		e.owners[s.key.owner] = owned
+	}
+	owned[s] = struct{}{}
+	e.streams[s.key] = s
+}
+
+// deleteStream reverses addStream and reports whether s was indexed. It
+// must be called with streamMu held.
+func (e *engine) deleteStream(s *inStream) bool {
+	if e.streams[s.key] != s {
+		return false
+	}
+	delete(e.streams, s.key)
+	owned := e.owners[s.key.owner]
+	delete(owned, s)
+	if len(owned) == 0 {
+		delete(e.owners, s.key.owner)
+	}
+	return true
+}
+
+func (e *engine) removeStream(s *inStream, err error) {
+	e.streamMu.Lock()
+	e.deleteStream(s)
+	e.streamMu.Unlock()
+	s.close(err)
+}
+
+// dropStreams fails the streams owned by conn when it disconnects, the
+// streams of other owners wait to be resumed from a new connection.
+func (e *engine) dropStreams(conn *connection) {
+	e.closeStreams(conn, ErrConnectionClosed)
+}
+
+// closeStreams fails the streams of owner, or every stream when owner is
+// nil.
+func (e *engine) closeStreams(owner interface{}, err error) {
+	var closed []*inStream
+	e.streamMu.Lock()
+	for _, s := range e.streams {
+		if owner == nil || s.key.owner == owner {
+			closed = append(closed, s)
+		}
+	}
+	for _, s := range closed {
+		e.deleteStream(s)
+	}
+	e.streamMu.Unlock()
+
+	for _, s := range closed {
+		s.close(err)
+	}
+}
+
+// handleStream serves the reserved stream cmds on the read loop and
+// reports whether body was one of them.
+func (e *engine) handleStream(conn *connection, body *MessageBody) bool {
+	var err error
+	switch body.Cmd {
+	case StreamStartCmd:
+		err = e.startStream(conn, body)
+	case StreamChunkCmd:
+		err = e.writeStream(conn, body)
+	case StreamEndCmd:
+		err = e.endStream(conn, body)
+	default:
+		return false
+	}
+	if err != nil {
+		logx.WithError(err).Errorf("websocket %s failed", body.Cmd)
+	}
+	return true
+}
+
+func (e *engine) startStream(conn *connection, body *MessageBody) error {
+	var req streamStart
+	err := conn.codec.Bind(body.Data, &req)
+	if err != nil {
+		return replyStream(conn, body, ErrInvalidParams, nil)
+	}
+	key := streamKey{owner: streamOwner(conn), id: req.ID}
+
+	e.streamMu.Lock()
+	if s, ok := e.streams[key]; ok {
+		// resume, possibly from a new connection of the same owner
+		s.conn = conn
+		e.streamMu.Unlock()
+
+		s.mu.Lock()
+		offset := s.offset
+		if s.err == nil {
+			s.timer.Reset(e.streamTimeout)
+		}
+		s.mu.Unlock()
+		return replyStream(conn, body, nil, &streamState{ID: req.ID, Offset: offset})
+	}
+	if len(e.owners[key.owner]) >= e.maxStreams {
+		e.streamMu.Unlock()
+		return replyStream(conn, body, ErrTooManyStreams, nil)
+	}
+	value, ok := e.routes.Match(req.Cmd)
+	if !ok {
+		e.streamMu.Unlock()
+		return replyStream(conn, body, ErrUnknownCmd, nil)
+	}
+
+	s := &inStream{
+		key:     key,
+		timeout: e.streamTimeout,
+		conn:    conn,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.stream = &Stream{
+		ID:   req.ID,
+		Size: req.Size,
+		Meta: req.Meta,
+		in:   s,
+	}
+	s.timer = time.AfterFunc(e.streamTimeout, func() {
+		e.removeStream(s, ErrStreamTimeout)
+	})
+	e.addStream(s)
+	e.streamMu.Unlock()
+
+	ctx := e.msgCtxPool.Get()
+	ctx.reset()
+	ctx.Conn = conn.conn
+	ctx.conn = conn
+	ctx.MessageType = conn.codec.MessageType()
+	ctx.Message = body.Data
+	ctx.JsonBody = &MessageBody{
+		Cmd:   req.Cmd,
+		Seqno: req.ID,
+		Data:  req.Meta,
+	}
+	ctx.Server = conn.server
+	ctx.Client = conn.client
+	ctx.stream = s.stream
+	ctx.route = value.(*routeEntry).cmd
+	ctx.handlers = value.(*routeEntry).chain
+
+	// the handler consumes the stream while the read loop feeds it
+	e.streamWg.Add(1)
+	go func() {
+		defer e.streamWg.Done()
+		e.run(ctx)
+		s.mu.Lock()
+		s.done = true
+		s.buf = nil
+		abandoned := len(s.acks) > 0
+		s.mu.Unlock()
+		// the chunks waiting for the handler to read them would never be
+		// acked
+		if abandoned {
+			e.removeStream(s, io.ErrClosedPipe)
+		}
+	}()
+
+	return replyStream(conn, body, nil, &streamState{ID: req.ID})
+}
+
+func (e *engine) writeStream(conn *connection, body *MessageBody) error {
+	var chunk streamChunk
+	err := conn.codec.Bind(body.Data, &chunk)
+	if err != nil {
+		return err
+	}
+	s, ok := e.ownedStream(conn, chunk.ID)
+	if !ok {
+		return ErrStreamNotFound
+	}
+	if crc32.ChecksumIEEE(chunk.Data) != chunk.Checksum {
+		e.removeStream(s, ErrStreamChecksum)
+		return ErrStreamChecksum
+	}
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return ErrStreamNotFound
+	}
+	if s.done {
+		s.mu.Unlock()
+		e.removeStream(s, io.ErrClosedPipe)
+		return io.ErrClosedPipe
+	}
+
+	// skip the part already received before a reconnect
+	data := chunk.Data
+	if chunk.Offset < s.offset {
+		skip := s.offset - chunk.Offset
+		if skip >= int64(len(data)) {
+			skip = int64(len(data))
+		}
+		data = data[skip:]
+	} else if chunk.Offset > s.offset {
+		s.mu.Unlock()
+		return ErrStreamOffset
+	}
+	if len(s.buf)+len(data) > streamBufferSize {
+		s.mu.Unlock()
+		e.removeStream(s, ErrStreamOverflow)
+		return ErrStreamOverflow
+	}
+
+	s.buf = append(s.buf, data...)
+	s.offset += int64(len(data))
+	if body.Seqno != "" {
+		s.acks = append(s.acks, streamAck{conn: conn, seqno: body.Seqno, offset: s.offset})
+	}
+	acks := s.takeAcks()
+	s.timer.Reset(e.streamTimeout)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	for _, ack := range acks {
+		s.reply(ack, nil)
+	}
+	return nil
+}
+
+func (e *engine) endStream(conn *connection, body *MessageBody) error {
+	var req streamEnd
+	err := conn.codec.Bind(body.Data, &req)
+	if err != nil {
+		return replyStream(conn, body, ErrInvalidParams, nil)
+	}
+	s, ok := e.ownedStream(conn, req.ID)
+	if !ok {
+		return replyStream(conn, body, ErrStreamNotFound, nil)
+	}
+
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+	if offset != req.Size || (s.stream.Size >= 0 && offset != s.stream.Size) {
+		e.removeStream(s, ErrStreamOffset)
+		return replyStream(conn, body, ErrStreamOffset, nil)
+	}
+
+	e.removeStream(s, io.EOF)
+	return replyStream(conn, body, nil, &streamState{ID: req.ID, Offset: offset})
+}
+
+func replyStream(conn *connection, body *MessageBody, failure *Error, state *streamState) error {
+	resp := &RespBody{
+		Cmd:   body.Cmd,
+		Seqno: body.Seqno,
+		Code:  OKCode,
+		Msg:   OKMsg,
+	}
+	if failure != nil {
+		resp.Code = failure.Code
+		resp.Msg = failure.Msg
+	}
+	if state != nil {
+		resp.Data = state
+	}
+	return conn.writeBody(resp)
+}
+
+// StreamWriter sends a chunked transfer to the peer. Writes are buffered
+// and sent in chunks, every streamAckChunks-th chunk waiting for the peer
+// to consume the previous ones. Close flushes the last chunk and waits for
+// the peer to confirm the size received.
+type StreamWriter struct {
+	conn      *connection
+	ctx       context.Context
+	id        string
+	offset    int64
+	chunkSize int
+	buf       []byte
+	chunks    int
+	closed    bool
+}
+
+// openStream announces a stream to the peer. When the peer already
+// received a part of a stream with the same id, Offset of the returned
+// writer tells where the data must continue.
+func (c *connection) openStream(ctx context.Context, cmd string, id string, size int64, meta interface{}) (*StreamWriter, error) {
+	var js json.RawMessage
+	if meta != nil {
+		var err error
+		js, err = json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.call(ctx, StreamStartCmd, &streamStart{
+		ID:   id,
+		Cmd:  cmd,
+		Size: size,
+		Meta: js,
+	})
+	if err != nil {
+		return nil, err
+	}
+	state, err := c.streamState(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamWriter{
+		conn:      c,
+		ctx:       ctx,
+		id:        id,
+		offset:    state.Offset,
+		chunkSize: defaultChunkSize,
+		buf:       make([]byte, 0, defaultChunkSize),
+	}, nil
+}
+
+func (c *connection) streamState(resp *RespBody) (*streamState, error) {
+	if resp.Code != OKCode {
+		return nil, NewError(resp.Code, resp.Msg)
+	}
+	var state streamState
+	data, _ := resp.Data.(json.RawMessage)
+	err := c.codec.Bind(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Offset returns the number of bytes received by the peer, including those
+// buffered by the writer.
+func (w *StreamWriter) Offset() int64 {
+	return w.offset + int64(len(w.buf))
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := 0
+	for len(p) > 0 {
+		free := w.chunkSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+		n += free
+		if len(w.buf) == w.chunkSize {
+			err := w.flush()
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *StreamWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	chunk := &streamChunk{
+		ID:       w.id,
+		Offset:   w.offset,
+		Checksum: crc32.ChecksumIEEE(w.buf),
+		Data:     w.buf,
+	}
+	w.chunks++
+	if w.chunks%streamAckChunks == 0 {
+		resp, err := w.conn.call(w.ctx, StreamChunkCmd, chunk)
+		if err != nil {
+			return err
+		}
+		if resp.Code != OKCode {
+			return NewError(resp.Code, resp.Msg)
+		}
+	} else {
+		err := w.conn.writeBody(&SendBody{
+			Cmd:  StreamChunkCmd,
+			Data: chunk,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.offset += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close sends the buffered data and ends the stream.
+func (w *StreamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+	w.closed = true
+
+	resp, err := w.conn.call(w.ctx, StreamEndCmd, &streamEnd{
+		ID:   w.id,
+		Size: w.offset,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.conn.streamState(resp)
+	return err
+}
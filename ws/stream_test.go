@@ -0,0 +1,336 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamUploadResume(t *testing.T) {
+	payload := make([]byte, defaultChunkSize*3+100)
+	rand.Read(payload)
+
+	// only the connections of an identity resume its streams
+	s := NewServer(WithAuthenticator(TokenAuth("token", func(token string) (interface{}, error) {
+		return token, nil
+	})))
+	received := make(chan []byte, 1)
+	s.OnCmd("upload", func(c *MessageContext) {
+		b, err := ioutil.ReadAll(c.Stream())
+		assert.NoError(t, err)
+		received <- b
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "?token=user-1"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// first connection sends two chunks and drops
+	first := NewClient(url)
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := first.OpenStream(ctx, "upload", "stream-1", int64(len(payload)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(0), w.Offset())
+	_, err = w.Write(payload[:defaultChunkSize*2+10])
+	assert.NoError(t, err)
+	_ = first.Close()
+
+	// second connection resumes
+	second := NewClient(url)
+	if err := second.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	w, err = second.OpenStream(ctx, "upload", "stream-1", int64(len(payload)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(defaultChunkSize*2), w.Offset())
+	_, err = w.Write(payload[w.Offset():])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	select {
+	case b := <-received:
+		assert.True(t, bytes.Equal(payload, b))
+	case <-ctx.Done():
+		t.Fatal("stream not received")
+	}
+}
+
+func TestStreamSizeMismatch(t *testing.T) {
+	s := NewServer()
+	s.OnCmd("upload", func(c *MessageContext) {
+		_, _ = ioutil.ReadAll(c.Stream())
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	w, err := cl.OpenStream(context.Background(), "upload", "stream-2", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("short"))
+	err = w.Close()
+	assert.Equal(t, ErrStreamOffset, err)
+}
+
+func TestStreamOwnership(t *testing.T) {
+	s := NewServer()
+	s.OnCmd("upload", func(c *MessageContext) {
+		_, _ = ioutil.ReadAll(c.Stream())
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	owner := NewClient(url)
+	if err := owner.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Close()
+	w, err := owner.OpenStream(context.Background(), "upload", "stream-3", -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte("data"))
+	assert.NoError(t, err)
+
+	other, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_ = other.SetReadDeadline(time.Now().Add(time.Second))
+
+	_ = other.WriteJSON(&SendBody{Cmd: StreamChunkCmd, Data: &streamChunk{ID: "stream-3", Checksum: crc32.ChecksumIEEE(nil)}})
+	_ = other.WriteJSON(&SendBody{Cmd: StreamEndCmd, Seqno: "1", Data: &streamEnd{ID: "stream-3"}})
+	var resp RespBody
+	assert.NoError(t, other.ReadJSON(&resp))
+	assert.Equal(t, ErrStreamNotFound.Code, resp.Code)
+
+	assert.NoError(t, w.Close())
+}
+
+func TestStreamMaxStreams(t *testing.T) {
+	s := NewServer(WithMaxStreams(1))
+	s.OnCmd("upload", func(c *MessageContext) {
+		_, _ = ioutil.ReadAll(c.Stream())
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	_, err := cl.OpenStream(context.Background(), "upload", "stream-4", -1, nil)
+	assert.NoError(t, err)
+	_, err = cl.OpenStream(context.Background(), "upload", "stream-5", -1, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrTooManyStreams.Code, err.(*Error).Code)
+	}
+}
+
+func TestStreamHandlerNotReading(t *testing.T) {
+	payload := make([]byte, streamBufferSize*2)
+	rand.Read(payload)
+
+	s := NewServer()
+	received := make(chan []byte, 1)
+	s.OnCmd("upload", func(c *MessageContext) {
+		// the read loop goes on while the stream is not read
+		_, err := c.Call(context.Background(), "question", nil)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(c.Stream())
+		assert.NoError(t, err)
+		received <- b
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	cl.OnCmd("question", func(c *MessageContext) {
+		_ = c.Reply("answer")
+	})
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	w, err := cl.OpenStream(ctx, "upload", "stream-6", int64(len(payload)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(payload)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	select {
+	case b := <-received:
+		assert.True(t, bytes.Equal(payload, b))
+	case <-ctx.Done():
+		t.Fatal("stream not received")
+	}
+}
+
+func TestStreamMaxStreamsPerOwner(t *testing.T) {
+	s := NewServer(WithMaxStreams(1), WithAuthenticator(TokenAuth("token", func(token string) (interface{}, error) {
+		return token, nil
+	})))
+	s.OnCmd("upload", func(c *MessageContext) {
+		_, _ = ioutil.ReadAll(c.Stream())
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "?token=user-2"
+
+	first := NewClient(url)
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := first.OpenStream(context.Background(), "upload", "stream-7", -1, nil)
+	assert.NoError(t, err)
+	_ = first.Close()
+
+	// the stream waiting to be resumed still counts for the identity
+	second := NewClient(url)
+	if err := second.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	_, err = second.OpenStream(context.Background(), "upload", "stream-8", -1, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, ErrTooManyStreams.Code, err.(*Error).Code)
+	}
+	w, err := second.OpenStream(context.Background(), "upload", "stream-7", -1, nil)
+	assert.NoError(t, err)
+	if w != nil {
+		assert.NoError(t, w.Close())
+	}
+}
+
+func TestStreamDownloadResume(t *testing.T) {
+	payload := make([]byte, defaultChunkSize*3+100)
+	rand.Read(payload)
+
+	s := NewServer()
+	type peerConn struct {
+		peer *Peer
+		conn *websocket.Conn
+	}
+	peers := make(chan peerConn, 2)
+	s.OnConnect(func(c *ConnContext) {
+		peers <- peerConn{c.Peer(), c.Conn}
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	// the streams sent to a client survive its reconnects
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	cl.SetBackoff(time.Millisecond*10, time.Millisecond*50)
+	received := make(chan []byte, 1)
+	cl.OnCmd("download", func(c *MessageContext) {
+		b, err := ioutil.ReadAll(c.Stream())
+		assert.NoError(t, err)
+		received <- b
+	})
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// the server sends two chunks and drops the connection
+	first := <-peers
+	w, err := first.peer.OpenStream(ctx, "download", "stream-9", int64(len(payload)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(payload[:defaultChunkSize*2+10])
+	assert.NoError(t, err)
+	_ = first.conn.Close()
+
+	// and resumes on the new connection of the client
+	var second peerConn
+	select {
+	case second = <-peers:
+	case <-ctx.Done():
+		t.Fatal("client not reconnected")
+	}
+	w, err = second.peer.OpenStream(ctx, "download", "stream-9", int64(len(payload)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(defaultChunkSize*2), w.Offset())
+	_, err = w.Write(payload[w.Offset():])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	select {
+	case b := <-received:
+		assert.True(t, bytes.Equal(payload, b))
+	case <-ctx.Done():
+		t.Fatal("stream not received")
+	}
+}
+
+func TestShutdownWaitsForStreams(t *testing.T) {
+	s := NewServer()
+	reading := make(chan struct{})
+	var finished int32
+	s.OnCmd("upload", func(c *MessageContext) {
+		close(reading)
+		_, _ = ioutil.ReadAll(c.Stream())
+		time.Sleep(time.Millisecond * 100)
+		atomic.StoreInt32(&finished, 1)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	cl := NewClient("ws" + strings.TrimPrefix(hs.URL, "http"))
+	if err := cl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	w, err := cl.OpenStream(context.Background(), "upload", "stream-10", -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte("data"))
+	assert.NoError(t, err)
+	<-reading
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+}
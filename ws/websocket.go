@@ -1,116 +1,211 @@
 package ws
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/xuzq3/glib/logx"
 )
 
 type Server struct {
-	upgrader        *websocket.Upgrader
-	msgCtxPool      *messageContextPool
-	connCtxPool     *connContextPool
-	connectHandlers []ConnHandler
-	messageHandlers map[string][]MessageHandler
+	engine
+	upgrader         *websocket.Upgrader
+	responseHeader   http.Header
+	compressionLevel int
+	authenticator    Authenticator
+	hub              *hub
+	broker           Broker
+	brokerCancel     context.CancelFunc
+	broadcastTimeout time.Duration
+
+	mu sync.Mutex
+	// conns are the connections Shutdown closes, accepted or not. The
+	// broadcasts only reach those accepted, see hub.
+	conns      map[*connection]struct{}
+	connWg     sync.WaitGroup
+	inShutdown bool
+	closeCode  int
+	closeText  string
 }
 
-func NewServer() *Server {
+func NewServer(opts ...Option) *Server {
 	upgrader := &websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
-	return &Server{
-		upgrader:        upgrader,
-		msgCtxPool:      newMessageContextPool(),
-		connCtxPool:     newConnectContextPool(),
-		messageHandlers: make(map[string][]MessageHandler),
+	s := &Server{
+		engine:    newEngine(),
+		upgrader:  upgrader,
+		hub:       newHub(),
+		conns:     make(map[*connection]struct{}),
+		closeCode: websocket.CloseGoingAway,
+
+		broadcastTimeout: defaultBroadcastTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.broker != nil {
+		var ctx context.Context
+		ctx, s.brokerCancel = context.WithCancel(context.Background())
+		go s.subscribe(ctx)
+	}
+	return s
+}
+
+// SetCloseCode sets the code and text of the close frame sent to every
+// connection by Shutdown.
+func (s *Server) SetCloseCode(code int, text string) {
+	s.closeCode = code
+	s.closeText = text
+}
+
+// SetCodec sets the codec of connections that didn't negotiate a
+// subprotocol. It defaults to the json codec.
+func (s *Server) SetCodec(codec Codec) {
+	s.codec = codec
+}
+
+// AddCodec registers codecs that clients can select through the
+// Sec-WebSocket-Protocol header, in order of server preference.
+func (s *Server) AddCodec(codecs ...Codec) {
+	for _, codec := range codecs {
+		s.codecs[codec.Name()] = codec
+		s.upgrader.Subprotocols = append(s.upgrader.Subprotocols, codec.Name())
 	}
 }
 
 func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	s.UpgradeWithKeys(w, r, nil)
+}
+
+// UpgradeWithKeys upgrades the request like Upgrade and stores keys in the
+// session of the connection before the connect handlers run.
+func (s *Server) UpgradeWithKeys(w http.ResponseWriter, r *http.Request, keys map[string]interface{}) {
+	if s.shuttingDown() {
+		http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var identity interface{}
+	if s.authenticator != nil {
+		var err error
+		identity, err = s.authenticator(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, s.responseHeader)
 	if err != nil {
 		logx.WithError(err).Error("websocket upgrade failed")
 		return
 	}
 	defer conn.Close()
 
-	s.handleConnect(conn)
-}
-
-func (s *Server) handleConnect(conn *websocket.Conn) {
-	ctx := s.connCtxPool.Get()
-	ctx.reset()
-	ctx.Conn = conn
-	ctx.Server = s
-	ctx.handlers = s.connectHandlers
+	if s.upgrader.EnableCompression {
+		conn.EnableWriteCompression(true)
+		err = conn.SetCompressionLevel(s.compressionLevel)
+		if err != nil {
+			logx.WithError(err).Error("websocket SetCompressionLevel failed")
+		}
+	}
 
-	defer s.connCtxPool.Put(ctx)
+	c := newConnection(conn, s.codecFor(conn.Subprotocol()), s.metrics)
+	c.server = s
+	c.request = r
+	for key, value := range keys {
+		c.session.Set(key, value)
+	}
+	if identity != nil {
+		c.session.Set(IdentityKey, identity)
+	}
+	s.handleConnect(c)
+}
 
-	ctx.Next()
+// Shutdown stops accepting upgrades, sends a close frame to every
+// connection and waits for their handlers, stream handlers included, to
+// return. If ctx expires first,
+// the remaining connections are closed forcibly and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	conns := make([]*connection, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
 
-	for {
-		messageType, message, err := conn.ReadMessage()
+	msg := websocket.FormatCloseMessage(s.closeCode, s.closeText)
+	deadline := time.Now().Add(time.Second)
+	for _, c := range conns {
+		err := c.conn.WriteControl(websocket.CloseMessage, msg, deadline)
 		if err != nil {
-			logx.WithError(err).Error("websocket ReadMessage failed")
-			return
-		}
-		switch messageType {
-		case websocket.TextMessage, websocket.BinaryMessage:
-			s.handleMessage(conn, messageType, message)
-		case websocket.CloseMessage:
-			return
-		default:
+			logx.WithError(err).Error("websocket write close message failed")
 		}
 	}
-}
 
-func (s *Server) handleMessage(conn *websocket.Conn, messageType int, message []byte) {
-	ctx, err := s.parseMessage(conn, messageType, message)
-	if err != nil {
-		logx.WithError(err).Error("websocket parseMessage failed")
-		return
+	done := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		// streams waiting to be resumed can't be anymore
+		s.closeStreams(nil, ErrServerClosed)
+		s.streamWg.Wait()
+		close(done)
+	}()
+
+	if s.brokerCancel != nil {
+		defer s.brokerCancel()
 	}
-	defer s.msgCtxPool.Put(ctx)
 
-	ctx.Next()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for c := range s.conns {
+			_ = c.conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
 }
 
-func (s *Server) parseMessage(conn *websocket.Conn, messageType int, message []byte) (*MessageContext, error) {
-	var body MessageBody
-	err := json.Unmarshal(message, &body)
-	if err != nil {
-		return nil, err
-	}
-	handlers, ok := s.messageHandlers[body.Cmd]
-	if !ok {
-		return nil, errors.New("unknown cmd")
-	}
-
-	ctx := s.msgCtxPool.Get()
-	ctx.reset()
-	ctx.Conn = conn
-	ctx.MessageType = messageType
-	ctx.Message = message
-	ctx.JsonBody = &body
-	ctx.Server = s
-	ctx.handlers = handlers
-	return ctx, nil
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
 }
 
-func (s *Server) OnConnect(handlers ...ConnHandler) {
-	s.connectHandlers = append(s.connectHandlers, handlers...)
+func (s *Server) trackConn(c *connection) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.connWg.Add(1)
+	return true
 }
 
-func (s *Server) OnCmd(cmd string, handlers ...MessageHandler) {
-	s.messageHandlers[cmd] = handlers
+func (s *Server) untrackConn(c *connection) {
+	s.hub.remove(c)
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.connWg.Done()
 }
 
-func (s *Server) Group(handlers ...MessageHandler) *Group {
-	return &Group{
-		server:   s,
-		handlers: handlers,
+func (s *Server) handleConnect(c *connection) {
+	if !s.trackConn(c) {
+		msg := websocket.FormatCloseMessage(s.closeCode, s.closeText)
+		_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
 	}
+	defer s.untrackConn(c)
+
+	s.serve(c)
 }
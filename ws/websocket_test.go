@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	s := NewServer(WithDispatch(DispatchConcurrent, 2))
+	s.SetCloseCode(websocket.CloseServiceRestart, "restart")
+	handling := make(chan struct{})
+	var finished int32
+	s.OnCmd("slow", func(c *MessageContext) {
+		close(handling)
+		time.Sleep(time.Millisecond * 100)
+		atomic.StoreInt32(&finished, 1)
+	})
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.WriteJSON(&SendBody{Cmd: "slow"})
+	<-handling
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Shutdown(ctx)
+	}()
+
+	// the close frame carries the code set, reading it answers the close
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	if assert.IsType(t, &websocket.CloseError{}, err) {
+		assert.Equal(t, websocket.CloseServiceRestart, err.(*websocket.CloseError).Code)
+		assert.Equal(t, "restart", err.(*websocket.CloseError).Text)
+	}
+
+	// upgrades are refused once the shutdown started
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// and Shutdown returns after the handlers
+	assert.NoError(t, <-errs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := NewServer()
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer hs.Close()
+
+	// a peer not reading never answers the close frame
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	// the close frame with the default code was sent before the
+	// connection was closed forcibly
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	if assert.IsType(t, &websocket.CloseError{}, err) {
+		assert.Equal(t, websocket.CloseGoingAway, err.(*websocket.CloseError).Code)
+	}
+}
@@ -0,0 +1,283 @@
+// Package wstest runs a ws.Server in process and talks to it through a
+// scripted client, for use in tests.
+package wstest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xuzq3/glib/ws"
+)
+
+var (
+	errTimeout = errors.New("timeout")
+)
+
+// Server is a ws.Server listening on a local httptest server.
+type Server struct {
+	*httptest.Server
+	// URL is the ws:// URL of the server.
+	URL string
+
+	ws *ws.Server
+}
+
+// NewServer starts s on a local httptest server. The caller should call
+// Close when finished.
+func NewServer(s *ws.Server) *Server {
+	hs := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	return &Server{
+		Server: hs,
+		URL:    "ws" + strings.TrimPrefix(hs.URL, "http"),
+		ws:     s,
+	}
+}
+
+// Close shuts the ws.Server down and then the httptest server.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = s.ws.Shutdown(ctx)
+	s.Server.Close()
+}
+
+// DialOption configures the connection opened by Dial.
+type DialOption func(o *dialOptions)
+
+type dialOptions struct {
+	header http.Header
+	codec  ws.Codec
+}
+
+// WithHeader sets the header of the upgrade request.
+func WithHeader(header http.Header) DialOption {
+	return func(o *dialOptions) {
+		o.header = header
+	}
+}
+
+// WithCodec requests codec as subprotocol and uses it for the messages of
+// the connection.
+func WithCodec(codec ws.Codec) DialOption {
+	return func(o *dialOptions) {
+		o.codec = codec
+	}
+}
+
+// Dial connects to the server and fails t if the upgrade fails.
+func (s *Server) Dial(t testing.TB, opts ...DialOption) *Conn {
+	t.Helper()
+	o := &dialOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	dialer := *websocket.DefaultDialer
+	if o.codec != nil {
+		dialer.Subprotocols = []string{o.codec.Name()}
+	} else {
+		o.codec = ws.NewJsonCodec()
+	}
+	conn, _, err := dialer.Dial(s.URL, o.header)
+	if err != nil {
+		t.Fatalf("wstest: dial %s failed: %v", s.URL, err)
+	}
+
+	c := &Conn{
+		Conn:   conn,
+		t:      t,
+		codec:  o.codec,
+		notify: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Conn is a client connection of the test. Inbound messages are queued
+// until taken by Expect or Call. Its methods fail the test on error and
+// must be called from the goroutine running the test.
+type Conn struct {
+	*websocket.Conn
+
+	t     testing.TB
+	codec ws.Codec
+
+	mu       sync.Mutex
+	messages []*ws.MessageBody
+	notify   chan struct{}
+	done     chan struct{}
+	err      error
+}
+
+func (c *Conn) read() {
+	defer close(c.done)
+	for {
+		_, message, err := c.Conn.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		var body ws.MessageBody
+		err = c.codec.Decode(message, &body)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+
+		c.mu.Lock()
+		c.messages = append(c.messages, &body)
+		close(c.notify)
+		c.notify = make(chan struct{})
+		c.mu.Unlock()
+	}
+}
+
+// Send sends cmd with a new seqno and returns the seqno.
+func (c *Conn) Send(cmd string, data interface{}) string {
+	c.t.Helper()
+	seqno := ws.NewSeqno()
+	c.WriteBody(&ws.SendBody{
+		Cmd:   cmd,
+		Seqno: seqno,
+		Data:  data,
+	})
+	return seqno
+}
+
+// Reply answers a message sent by a server Call.
+func (c *Conn) Reply(req *ws.MessageBody, data interface{}) {
+	c.t.Helper()
+	c.WriteBody(&ws.RespBody{
+		Cmd:   req.Cmd,
+		Seqno: req.Seqno,
+		Code:  ws.OKCode,
+		Msg:   ws.OKMsg,
+		Data:  data,
+	})
+}
+
+// WriteBody encodes v with the codec of the connection and sends it.
+func (c *Conn) WriteBody(v interface{}) {
+	c.t.Helper()
+	b, err := c.codec.Encode(v)
+	if err != nil {
+		c.t.Fatalf("wstest: encode %T failed: %v", v, err)
+	}
+	err = c.Conn.WriteMessage(c.codec.MessageType(), b)
+	if err != nil {
+		c.t.Fatalf("wstest: write message failed: %v", err)
+	}
+}
+
+// Expect waits for the next message with cmd. Messages of other cmds stay
+// queued.
+func (c *Conn) Expect(cmd string, timeout time.Duration) *ws.MessageBody {
+	c.t.Helper()
+	body, err := c.wait(timeout, func(body *ws.MessageBody) bool {
+		return body.Cmd == cmd
+	})
+	if err != nil {
+		c.t.Fatalf("wstest: expect %s failed: %v", cmd, err)
+	}
+	return body
+}
+
+// Call sends cmd and waits for the response with the same seqno.
+func (c *Conn) Call(cmd string, data interface{}, timeout time.Duration) *ws.RespBody {
+	c.t.Helper()
+	seqno := c.Send(cmd, data)
+	body, err := c.wait(timeout, func(body *ws.MessageBody) bool {
+		return body.Seqno == seqno
+	})
+	if err != nil {
+		c.t.Fatalf("wstest: call %s failed: %v", cmd, err)
+	}
+	return &ws.RespBody{
+		Cmd:   body.Cmd,
+		Seqno: body.Seqno,
+		Code:  body.Code,
+		Msg:   body.Msg,
+		Data:  body.Data,
+	}
+}
+
+// Bind decodes the payload of body into v with the codec of the
+// connection.
+func (c *Conn) Bind(body *ws.MessageBody, v interface{}) {
+	c.t.Helper()
+	err := c.codec.Bind(body.Data, v)
+	if err != nil {
+		c.t.Fatalf("wstest: bind %s failed: %v", body.Cmd, err)
+	}
+}
+
+func (c *Conn) wait(timeout time.Duration, match func(body *ws.MessageBody) bool) (*ws.MessageBody, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		for i, body := range c.messages {
+			if match(body) {
+				c.messages = append(c.messages[:i], c.messages[i+1:]...)
+				c.mu.Unlock()
+				return body, nil
+			}
+		}
+		notify, err := c.notify, c.err
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-notify:
+		case <-c.done:
+		case <-timer.C:
+			return nil, errTimeout
+		}
+	}
+}
+
+// ExpectClose waits for the server to close the connection and fails t
+// unless the close code is code.
+func (c *Conn) ExpectClose(code int, timeout time.Duration) *websocket.CloseError {
+	c.t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		c.t.Fatalf("wstest: expect close %d failed: %v", code, errTimeout)
+	}
+
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	ce, ok := err.(*websocket.CloseError)
+	if !ok {
+		c.t.Fatalf("wstest: expect close %d, got %v", code, err)
+	}
+	if ce.Code != code {
+		c.t.Errorf("wstest: expect close %d, got %d %q", code, ce.Code, ce.Text)
+	}
+	return ce
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	err := c.Conn.Close()
+	<-c.done
+	return err
+}
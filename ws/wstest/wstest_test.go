@@ -0,0 +1,90 @@
+package wstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuzq3/glib/ws"
+)
+
+type echoReq struct {
+	Text string `json:"text"`
+}
+
+func TestConn(t *testing.T) {
+	// a handler calling the client needs the read loop to go on
+	s := ws.NewServer(ws.WithDispatch(ws.DispatchConcurrent, 2))
+	s.OnCmd("echo", func(c *ws.MessageContext) {
+		var req echoReq
+		if err := c.ShouldBindJson(&req); err != nil {
+			_ = c.ReplyError(ws.ErrInvalidParams)
+			return
+		}
+		_ = c.Reply(&req)
+	})
+	s.OnCmd("notify", func(c *ws.MessageContext) {
+		_ = c.WriteBody(&ws.SendBody{Cmd: "notified", Data: &echoReq{Text: "hi"}})
+	})
+	s.OnCmd("ask", func(c *ws.MessageContext) {
+		ctx, cancel := context.WithTimeout(c.Context(), time.Second)
+		defer cancel()
+		resp, err := c.Call(ctx, "question", nil)
+		if err != nil {
+			_ = c.ReplyError(err)
+			return
+		}
+		_ = c.Reply(resp.Data)
+	})
+	hs := NewServer(s)
+	defer hs.Close()
+
+	conn := hs.Dial(t)
+	defer conn.Close()
+
+	resp := conn.Call("echo", &echoReq{Text: "hello"}, time.Second)
+	assert.Equal(t, ws.OKCode, resp.Code)
+	assert.JSONEq(t, `{"text":"hello"}`, string(resp.Data.(json.RawMessage)))
+
+	conn.Send("notify", nil)
+	msg := conn.Expect("notified", time.Second)
+	var req echoReq
+	conn.Bind(msg, &req)
+	assert.Equal(t, "hi", req.Text)
+
+	seqno := conn.Send("ask", nil)
+	question := conn.Expect("question", time.Second)
+	conn.Reply(question, "answer")
+	answer := conn.Expect("ask", time.Second)
+	assert.Equal(t, seqno, answer.Seqno)
+	assert.JSONEq(t, `"answer"`, string(answer.Data))
+}
+
+func TestConnExpectClose(t *testing.T) {
+	s := ws.NewServer()
+	s.OnConnect(func(c *ws.ConnContext) {
+		c.AbortWithError(errors.New("denied"))
+	})
+	hs := NewServer(s)
+	defer hs.Close()
+
+	conn := hs.Dial(t)
+	defer conn.Close()
+	ce := conn.ExpectClose(websocket.ClosePolicyViolation, time.Second)
+	assert.Equal(t, "denied", ce.Text)
+}
+
+func TestServerClose(t *testing.T) {
+	s := ws.NewServer()
+	s.SetCloseCode(websocket.CloseGoingAway, "bye")
+	hs := NewServer(s)
+
+	conn := hs.Dial(t)
+	defer conn.Close()
+	hs.Close()
+	conn.ExpectClose(websocket.CloseGoingAway, time.Second)
+}